@@ -3,58 +3,374 @@ package main
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
 	"github.com/zahartd/load_balancer/internal/balancer"
 	"github.com/zahartd/load_balancer/internal/config"
 	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/metrics"
 	"github.com/zahartd/load_balancer/internal/ratelimit"
+	"github.com/zahartd/load_balancer/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 const gracefulShutdownTime = 7 * time.Second // TODD: move it to env
 
+// defaultUpstream is name of the backends pool in metrics
+const defaultUpstream = "default"
+
 func main() {
-	log.Println("Try to start load balancer...")
 	appCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configs: %s\n", err.Error())
+		fatal("Failed to load configs", err)
+	}
+	baseLogger, err := logging.New(cfg.Log, os.Stderr)
+	if err != nil {
+		fatal("Invalid log config", err)
 	}
+	// Logs of the standard log package, e.g. of the reverse proxy, go to the same output
+	slog.SetDefault(baseLogger)
+	logger := logging.Component(baseLogger, "main")
+	logger.Info("Try to start load balancer...")
 
-	lb := balancer.New(appCtx, cfg.Backends, cfg.LoadBalancer)
-	rl := ratelimit.New(cfg.RateLimit.Algorithm, cfg.RateLimit.Options)
+	// Metrics are served by the admin API, without it there is nobody to read them
+	var m *metrics.Metrics
+	if cfg.Admin.Port != 0 {
+		m = metrics.New()
+	}
 
-	r := httpGateway.NewServer(
+	// Flat list of backends is pool of requests without route
+	var lb *balancer.LoadBalancer
+	if len(cfg.Backends) > 0 {
+		lb = balancer.New(
+			appCtx,
+			cfg.Backends,
+			cfg.LoadBalancer,
+			balancer.WithLogger(baseLogger.With("upstream", defaultUpstream)),
+			m.HealthCheckObserver(defaultUpstream),
+		)
+		m.WatchUpstream(defaultUpstream, lb)
+	}
+	upstreams := make(map[string]*balancer.LoadBalancer, len(cfg.Upstreams))
+	for name, uc := range cfg.Upstreams {
+		upstreams[name] = balancer.New(
+			appCtx,
+			uc.Backends,
+			upstreamBalancer(uc.Balancer, cfg.LoadBalancer),
+			balancer.WithLogger(baseLogger.With("upstream", name)),
+			m.HealthCheckObserver(name),
+		)
+		m.WatchUpstream(name, upstreams[name])
+	}
+	limiterOptions := []func(*ratelimit.RateLimiter){
+		ratelimit.WithIdleTTL(cfg.RateLimit.IdleTTLMS.AsDuration()),
+		ratelimit.WithSweepInterval(cfg.RateLimit.SweepIntervalMS.AsDuration()),
+		ratelimit.WithMaxClients(cfg.RateLimit.MaxClients),
+	}
+
+	var (
+		cluster    *ratelimit.Cluster
+		peerServer *http.Server
+	)
+	if cfg.RateLimit.Peers != nil {
+		cluster, err = ratelimit.NewCluster(appCtx, *cfg.RateLimit.Peers, ratelimit.WithClusterLogger(baseLogger))
+		if err != nil {
+			fatal("Invalid rate limit peers config", err)
+		}
+		peerServer = newPeerServer(cfg.RateLimit.Peers, cluster)
+	}
+
+	var plans *ratelimit.Plans
+	if cfg.RateLimit.Plans != nil {
+		plans, err = ratelimit.NewPlans(*cfg.RateLimit.Plans)
+		if err != nil {
+			fatal("Invalid plans config", err)
+		}
+	}
+
+	rl, err := ratelimit.New(
 		appCtx,
-		lb,
-		rl,
+		cfg.RateLimit.Algorithm,
+		algorithmOptions(cfg.RateLimit.Algorithm, cfg.RateLimit.Options, plans),
+		withPolicy(limiterOptions, baseLogger, cluster, httpGateway.GlobalPolicyName)...,
+	)
+	if err != nil {
+		fatal("Invalid rate limit config", err)
+	}
+
+	m.WatchLimiter(httpGateway.GlobalPolicyName, rl)
+
+	keys, err := httpGateway.NewKeyExtractor(cfg.RateLimit.ClientKey, cfg.Server.TrustedProxies)
+	if err != nil {
+		fatal("Invalid client key config", err)
+	}
+
+	routes, err := httpGateway.NewRoutes(cfg.Routes, upstreams, keys)
+	if err != nil {
+		fatal("Invalid routes config", err)
+	}
+
+	policies, err := newRateLimitPolicies(appCtx, cfg, keys, cluster, plans, baseLogger, limiterOptions...)
+	if err != nil {
+		fatal("Invalid rate limit policies config", err)
+	}
+	for _, policy := range policies {
+		m.WatchLimiter(policy.Name, policy.Limiter)
+	}
+
+	accessList, err := httpGateway.NewAccessList(cfg.AccessList)
+	if err != nil {
+		fatal("Invalid access list", err)
+	}
+	go accessList.Watch(logging.NewContext(appCtx, baseLogger), cfg.AccessList.ReloadIntervalMS.AsDuration())
+
+	dryRunStats := httpGateway.NewDryRunStats()
+	serverOptions := []func(*httpGateway.Server){
 		httpGateway.WithHost(cfg.Server.Host),
 		httpGateway.WithPort(cfg.Server.Port),
-	)
+		httpGateway.WithKeyExtractor(keys),
+		httpGateway.WithRequestCost(httpGateway.NewRequestCost(cfg.RateLimit.Cost, cfg.Server.TrustedProxies)),
+		httpGateway.WithRateLimitPolicies(policies...),
+		httpGateway.WithRateLimitDryRun(cfg.RateLimit.DryRun),
+		httpGateway.WithDryRunStats(dryRunStats),
+		httpGateway.WithAccessList(accessList),
+		httpGateway.WithTrustedProxies(cfg.Server.TrustedProxies),
+		httpGateway.WithRoutes(routes...),
+		httpGateway.WithMetrics(m),
+		httpGateway.WithLogger(baseLogger),
+	}
+	if plans != nil {
+		serverOptions = append(serverOptions, httpGateway.WithConcurrencyLimiter(
+			ratelimit.NewConcurrencyLimiter(
+				cfg.RateLimit.Concurrency.MaxInFlight,
+				ratelimit.WithInFlightLimits(plans.MaxInFlight),
+			),
+		))
+	} else if cfg.RateLimit.Concurrency.MaxInFlight > 0 {
+		serverOptions = append(serverOptions, httpGateway.WithConcurrencyLimiter(
+			ratelimit.NewConcurrencyLimiter(cfg.RateLimit.Concurrency.MaxInFlight),
+		))
+	}
+	var tracerProvider *sdktrace.TracerProvider
+	if cfg.Tracing.Exporter != "" {
+		tracerProvider, err = tracing.New(appCtx, cfg.Tracing)
+		if err != nil {
+			fatal("Invalid tracing config", err)
+		}
+		serverOptions = append(serverOptions, httpGateway.WithTracerProvider(tracerProvider))
+	}
+	var quota *ratelimit.Quota
+	if cfg.RateLimit.Quota != nil {
+		var store ratelimit.QuotaStore
+		if cfg.RateLimit.Quota.StorePath != "" {
+			store = ratelimit.NewFileQuotaStore(cfg.RateLimit.Quota.StorePath)
+		}
+		quotaOptions := []func(*ratelimit.Quota){ratelimit.WithQuotaLogger(baseLogger)}
+		if plans != nil {
+			quotaOptions = append(quotaOptions,
+				ratelimit.WithQuotaLimits(plans.QuotaLimit),
+				// Paid clients are listed in the config, flood of new keys must not renew their quota
+				ratelimit.WithQuotaPinned(plans.Configured),
+			)
+		}
+		quota, err = ratelimit.NewQuota(appCtx, *cfg.RateLimit.Quota, store, quotaOptions...)
+		if err != nil {
+			fatal("Invalid quota config", err)
+		}
+		serverOptions = append(serverOptions, httpGateway.WithQuota(quota))
+	}
+	r := httpGateway.NewServer(appCtx, lb, rl, serverOptions...)
+
+	var admin *httpGateway.AdminServer
+	if cfg.Admin.Port != 0 {
+		adminOptions := []func(*httpGateway.AdminServer){
+			httpGateway.WithAdminHost(cfg.Admin.Host),
+			httpGateway.WithAdminPort(cfg.Admin.Port),
+			httpGateway.WithDryRunAdmin(dryRunStats),
+			httpGateway.WithAccessListAdmin(accessList),
+			httpGateway.WithRoutesAdmin(routes),
+			httpGateway.WithMetricsAdmin(m),
+			httpGateway.WithAdminLogger(baseLogger),
+		}
+		if quota != nil {
+			adminOptions = append(adminOptions, httpGateway.WithQuotaAdmin(quota))
+		}
+		if plans != nil {
+			adminOptions = append(adminOptions, httpGateway.WithPlansAdmin(plans))
+		}
+		admin = httpGateway.NewAdminServer(adminOptions...)
+		go func() {
+			if err := admin.Run(appCtx); err != nil {
+				fatal("Admin server failed", err)
+			}
+		}()
+	}
 
 	go func() {
 		if err := r.Run(appCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("Server failed: %s\n", err.Error())
+			fatal("Server failed", err)
 		}
 	}()
 
+	if peerServer != nil {
+		go func() {
+			logger.Info("Start rate limit peer listener", "address", peerServer.Addr)
+			if err := peerServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Peer listener failed", err)
+			}
+		}()
+	}
+
 	<-appCtx.Done()
 
 	stop()
-	log.Println("shutting down gracefully, press Ctrl+C again to force")
+	logger.Info("Shutting down gracefully, press Ctrl+C again to force")
 
 	shutdownCtx, shutdown := context.WithTimeout(context.Background(), gracefulShutdownTime)
 	defer shutdown()
 	if err := r.Shutdown(shutdownCtx); err != nil {
-		log.Fatal("Server forced to shutdown: ", err)
+		fatal("Server forced to shutdown", err)
+	}
+	if peerServer != nil {
+		if err := peerServer.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("Peer listener forced to shutdown", logging.ErrorKey, err)
+		}
+	}
+	if admin != nil {
+		if err := admin.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("Admin server forced to shutdown", logging.ErrorKey, err)
+		}
 	}
+	if quota != nil {
+		// All requests are finished, so counters are final
+		quota.Flush()
+	}
+	if tracerProvider != nil {
+		if err := tracerProvider.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("Failed to export remaining spans", logging.ErrorKey, err)
+		}
+	}
+
+	logger.Info("Server exiting")
+}
 
-	log.Println("Server exiting")
+// fatal logs the error and stops the process
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.ErrorKey, err)
+	os.Exit(1)
+}
+
+// newRateLimitPolicies creates separate limiter for every configured policy.
+// Policies without own client key config identify clients the same way as the global one.
+func newRateLimitPolicies(
+	ctx context.Context,
+	cfg *config.Config,
+	globalKeys httpGateway.KeyExtractor,
+	cluster *ratelimit.Cluster,
+	plans *ratelimit.Plans,
+	logger *slog.Logger,
+	limiterOptions ...func(*ratelimit.RateLimiter),
+) ([]httpGateway.RateLimitPolicy, error) {
+	policies := make([]httpGateway.RateLimitPolicy, 0, len(cfg.RateLimit.Policies))
+	names := map[string]bool{httpGateway.GlobalPolicyName: true}
+	for _, pc := range cfg.RateLimit.Policies {
+		// Limiters, metrics and dry-run stats of the policy are known by its name
+		if names[pc.Name] {
+			return nil, fmt.Errorf("policy %q: name is reserved or used by another policy", pc.Name)
+		}
+		names[pc.Name] = true
+
+		match, err := httpGateway.NewRequestMatcher(pc.Match)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", pc.Name, err)
+		}
+
+		keys := globalKeys
+		if pc.ClientKey != nil {
+			keys, err = httpGateway.NewKeyExtractor(*pc.ClientKey, cfg.Server.TrustedProxies)
+			if err != nil {
+				return nil, fmt.Errorf("policy %q: %w", pc.Name, err)
+			}
+		}
+
+		limiter, err := ratelimit.New(
+			ctx,
+			pc.Algorithm,
+			algorithmOptions(pc.Algorithm, pc.Options, plans),
+			withPolicy(limiterOptions, logger, cluster, pc.Name)...,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", pc.Name, err)
+		}
+		policies = append(policies, httpGateway.RateLimitPolicy{
+			Name:    pc.Name,
+			Match:   match,
+			Limiter: limiter,
+			Keys:    keys,
+			Cost:    httpGateway.NewRequestCost(pc.Cost, cfg.Server.TrustedProxies),
+			DryRun:  pc.DryRun,
+		})
+	}
+	return policies, nil
+}
+
+// withPolicy adds options of the named policy limiter: logger with the policy name
+// and cluster option if limits are shared between replicas
+func withPolicy(
+	limiterOptions []func(*ratelimit.RateLimiter),
+	logger *slog.Logger,
+	cluster *ratelimit.Cluster,
+	name string,
+) []func(*ratelimit.RateLimiter) {
+	options := append(slices.Clone(limiterOptions), ratelimit.WithLogger(logger.With("policy", name)))
+	if cluster != nil {
+		options = append(options, ratelimit.WithCluster(cluster, name))
+	}
+	return options
+}
+
+// newPeerServer creates listener for requests of other replicas of the cluster
+func newPeerServer(cfg *config.PeersConfig, cluster *ratelimit.Cluster) *http.Server {
+	addr := cfg.Listen
+	if addr == "" {
+		addr = cfg.Self
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           cluster.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
+// algorithmOptions returns options of the limiter, plan algorithm takes limits from the shared plans
+func algorithmOptions(algorithm string, options any, plans *ratelimit.Plans) any {
+	if algorithm != "plan" {
+		return options
+	}
+	if plans == nil {
+		fatal("Invalid rate limit config", errors.New("plan rate limit algorithm requires plans config"))
+	}
+	return plans
+}
+
+// upstreamBalancer fills missing settings of the upstream pool from the global balancer config
+func upstreamBalancer(cfg config.LoadBalancerConfig, global config.LoadBalancerConfig) config.LoadBalancerConfig {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = global.Algorithm
+	}
+	if cfg.HealthCheckIntervalMS == 0 {
+		cfg.HealthCheckIntervalMS = global.HealthCheckIntervalMS
+	}
+	return cfg
 }
@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"time"
@@ -12,6 +12,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
 	"github.com/zahartd/load_balancer/internal/models"
 )
 
@@ -23,9 +24,33 @@ type LoadBalancer struct {
 	// Currently, the backend list is statistical and sets at the start of the application through the config
 	// TODO: Make it dynamicly and added synchronization
 	backends []*models.Backend
+
+	// observeHealthCheck is called after every health check of the backend
+	observeHealthCheck func(backend *url.URL, duration time.Duration, alive bool)
+
+	logger *slog.Logger
+}
+
+// WithLogger sets logger of the balancer, by default it is slog.Default()
+func WithLogger(logger *slog.Logger) func(*LoadBalancer) {
+	return func(lb *LoadBalancer) {
+		lb.logger = logger
+	}
 }
 
-func New(ctx context.Context, backendsConfigs []config.BackendConfig, config config.LoadBalancerConfig) *LoadBalancer {
+// WithHealthCheckObserver sets function called with duration and result of every health check
+func WithHealthCheckObserver(observe func(backend *url.URL, duration time.Duration, alive bool)) func(*LoadBalancer) {
+	return func(lb *LoadBalancer) {
+		lb.observeHealthCheck = observe
+	}
+}
+
+func New(
+	ctx context.Context,
+	backendsConfigs []config.BackendConfig,
+	config config.LoadBalancerConfig,
+	options ...func(*LoadBalancer),
+) *LoadBalancer {
 	// Set backends list on startup (this list is constant in all time of app working)
 	// Therefore, we consider access to backends from different flows safe
 	backends := make([]*models.Backend, 0, len(backendsConfigs))
@@ -40,6 +65,10 @@ func New(ctx context.Context, backendsConfigs []config.BackendConfig, config con
 		balancer: CreateAlgorithm(config.Algorithm),
 		backends: backends,
 	}
+	for _, o := range options {
+		o(lb)
+	}
+	lb.logger = logging.Component(lb.logger, "balancer")
 
 	// Start in separate goroutine periodical task with healthchecking
 	healthCheckInterval := config.HealthCheckIntervalMS.AsDuration()
@@ -52,6 +81,11 @@ func (lb *LoadBalancer) AliveBackends() int {
 	return len(lb.getAlive())
 }
 
+// Backends returns all backends of the pool, alive or not
+func (lb *LoadBalancer) Backends() []*models.Backend {
+	return lb.backends
+}
+
 func (lb *LoadBalancer) getAlive() []*models.Backend {
 	// Fixed current states of backends
 	// Non-blocking for other goroutines
@@ -95,20 +129,22 @@ func (lb *LoadBalancer) healthCheck(ctx context.Context, client *http.Client) {
 				return fmt.Errorf("bad request for %s with %v", healthURL, err)
 			}
 
+			start := time.Now()
 			resp, err := client.Do(req)
 			alive := err == nil && resp.StatusCode == http.StatusOK
 			if resp != nil {
 				resp.Body.Close()
 			}
+			if lb.observeHealthCheck != nil && egCtx.Err() == nil {
+				lb.observeHealthCheck(b.URL, time.Since(start), alive)
+			}
 
-			b.SetAlive(alive)
-
-			log.Printf("backend health update: url=%s alive=%t", b.URL, alive)
+			lb.setAlive(b, alive)
 			return nil
 		})
 	}
 	if err := eg.Wait(); err != nil && err != context.Canceled {
-		log.Printf("health checks terminated early: %v", err)
+		lb.logger.Warn("Health checks terminated early", logging.ErrorKey, err)
 	}
 }
 
@@ -118,7 +154,7 @@ func (lb *LoadBalancer) NextBackend() (*models.Backend, error) {
 	alives := lb.getAlive()
 
 	if len(alives) == 0 {
-		log.Println("There is no available backend")
+		lb.logger.Warn("There is no available backend")
 		return nil, ErrNoAvailableBackends
 	}
 
@@ -131,12 +167,22 @@ func (lb *LoadBalancer) NextBackend() (*models.Backend, error) {
 func (lb *LoadBalancer) MarkBackendStatus(url string, alive bool) {
 	for _, b := range lb.backends {
 		if b.URL.String() == url {
-			b.SetAlive(alive)
-			log.Printf(
-				"backend health update: url=%s alive=%t",
-				url, alive,
-			)
+			lb.setAlive(b, alive)
 			return
 		}
 	}
 }
+
+// setAlive updates health of the backend, only changes are logged
+func (lb *LoadBalancer) setAlive(b *models.Backend, alive bool) {
+	if b.IsAlive() == alive {
+		lb.logger.Debug("Backend health is checked", logging.BackendKey, b.URL.String(), "alive", alive)
+		return
+	}
+	b.SetAlive(alive)
+	level := slog.LevelInfo
+	if !alive {
+		level = slog.LevelWarn
+	}
+	lb.logger.Log(context.Background(), level, "Backend health is changed", logging.BackendKey, b.URL.String(), "alive", alive)
+}
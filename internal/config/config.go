@@ -2,7 +2,9 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/netip"
 	"net/url"
 	"os"
 	"time"
@@ -11,6 +13,52 @@ import (
 type ServerConfig struct {
 	Host string `json:"host"`
 	Port uint16 `json:"port"`
+	// TrustedProxies are addresses of proxies whose forwarding headers (X-Forwarded-For) can be trusted
+	TrustedProxies CIDRList `json:"trusted_proxies"`
+}
+
+// CIDRList is list of networks, single addresses are accepted as /32 or /128 networks
+type CIDRList []netip.Prefix
+
+func (l *CIDRList) UnmarshalJSON(b []byte) error {
+	var raw []string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("CIDRList: expected list of CIDR strings, got %s: %w", string(b), err)
+	}
+	prefixes := make(CIDRList, 0, len(raw))
+	for _, r := range raw {
+		prefix, err := ParsePrefix(r)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	*l = prefixes
+	return nil
+}
+
+// ParsePrefix parses CIDR or single IP address
+func ParsePrefix(raw string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(raw); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(raw)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR or IP address %q: %w", raw, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Contains reports whether addr belongs to any network of the list
+func (l CIDRList) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range l {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 type DurationMs time.Duration
@@ -38,14 +86,296 @@ type TokenBucketLimiterOptions struct {
 	DefaultRefillIntervalMS DurationMs `json:"refill_interval_ms"`
 }
 
+type SlidingWindowLogLimiterOptions struct {
+	Limit    int        `json:"limit"`
+	WindowMS DurationMs `json:"window_ms"`
+}
+
+type SlidingWindowCounterLimiterOptions struct {
+	Limit    int        `json:"limit"`
+	WindowMS DurationMs `json:"window_ms"`
+}
+
+func (o SlidingWindowLogLimiterOptions) validate() error {
+	return validateWindow(o.Limit, o.WindowMS)
+}
+
+func (o SlidingWindowCounterLimiterOptions) validate() error {
+	return validateWindow(o.Limit, o.WindowMS)
+}
+
+func (o GCRALimiterOptions) validate() error {
+	if o.PeriodMS <= 0 {
+		return errors.New("period_ms must be positive")
+	}
+	if o.Rate <= 0 {
+		return errors.New("rate must be positive")
+	}
+	if o.Burst < 0 || o.MaxDelayMS < 0 {
+		return errors.New("burst and max_delay_ms must not be negative")
+	}
+	return nil
+}
+
+func (o RedisTokenBucketLimiterOptions) validate() error {
+	if o.Address == "" {
+		return errors.New("address is required")
+	}
+	if o.Capacity <= 0 {
+		return errors.New("capacity must be positive")
+	}
+	if o.RefillIntervalMS <= 0 {
+		return errors.New("refill_interval_ms must be positive")
+	}
+	if o.TimeoutMS < 0 || o.SyncIntervalMS < 0 {
+		return errors.New("timeout_ms and sync_interval_ms must not be negative")
+	}
+	switch o.Mode {
+	case "", "sync", "local_cache":
+	default:
+		return fmt.Errorf("unknown mode %q", o.Mode)
+	}
+	return nil
+}
+
+func validateWindow(limit int, window DurationMs) error {
+	if window <= 0 {
+		return errors.New("window_ms must be positive")
+	}
+	if limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	return nil
+}
+
+type GCRALimiterOptions struct {
+	Rate       int        `json:"rate"`
+	PeriodMS   DurationMs `json:"period_ms"`
+	Burst      int        `json:"burst"`
+	Shaping    bool       `json:"shaping"`
+	MaxDelayMS DurationMs `json:"max_delay_ms"`
+}
+
+// PlanLimits bundles limits of the clients plan, zero fields mean no limit
+type PlanLimits struct {
+	// Rate requests per PeriodMS with Burst requests allowed at once
+	Rate        int        `json:"rate"`
+	PeriodMS    DurationMs `json:"period_ms"`
+	Burst       int        `json:"burst"`
+	MaxInFlight int        `json:"max_in_flight"`
+	// QuotaLimit is number of requests per quota period (see QuotaConfig)
+	QuotaLimit int `json:"quota_limit"`
+}
+
+// PlanOverrides replaces individual limits of the plan for one client, nil fields are taken from the plan
+type PlanOverrides struct {
+	Rate        *int        `json:"rate"`
+	PeriodMS    *DurationMs `json:"period_ms"`
+	Burst       *int        `json:"burst"`
+	MaxInFlight *int        `json:"max_in_flight"`
+	QuotaLimit  *int        `json:"quota_limit"`
+}
+
+type ClientPlanConfig struct {
+	Plan      string        `json:"plan"`
+	Overrides PlanOverrides `json:"overrides"`
+}
+
+// PlansConfig describes named plans (e.g. free, pro, enterprise) and clients subscribed to them
+type PlansConfig struct {
+	Definitions map[string]PlanLimits `json:"definitions"`
+	// Default is plan of clients not listed in Clients
+	Default string                      `json:"default"`
+	Clients map[string]ClientPlanConfig `json:"clients"`
+}
+
+// KeyExtractorConfig describes how to get client identity from request.
+// Type is one of: header, client_ip, jwt_claim, query, path, method, composite.
+type KeyExtractorConfig struct {
+	Type string `json:"type"`
+	// Name of header (header, jwt_claim) or query parameter (query)
+	Name string `json:"name"`
+	// Claim of JWT payload (jwt_claim)
+	Claim string `json:"claim"`
+	// Parts of composite key, all of them must be present
+	Parts []KeyExtractorConfig `json:"parts"`
+}
+
+type ClientKeyConfig struct {
+	// Extractors are tried in order, the first found key is used
+	Extractors []KeyExtractorConfig `json:"extractors"`
+	// Anonymous is policy for requests without any key: reject (default) or client_ip
+	Anonymous string `json:"anonymous"`
+}
+
+// MatchConfig selects requests by host, path, method and headers, empty fields match everything
+type MatchConfig struct {
+	Host       string   `json:"host"`
+	PathPrefix string   `json:"path_prefix"`
+	PathRegex  string   `json:"path_regex"`
+	Methods    []string `json:"methods"`
+	// Headers must have exactly these values, empty value means header must be present
+	Headers map[string]string `json:"headers"`
+}
+
+// CostConfig describes how many units of the limit (e.g. tokens) request takes, by default 1
+type CostConfig struct {
+	// Fixed cost of every request
+	Fixed int `json:"fixed"`
+	// Header with cost set by trusted upstream, it is ignored for requests not from trusted proxies
+	Header string `json:"header"`
+	// BodyBytesPerUnit charges one unit per every such number of body bytes
+	BodyBytesPerUnit int64 `json:"body_bytes_per_unit"`
+	// Max cost of single request, it is required with BodyBytesPerUnit: request with unknown body size
+	// (chunked) is charged with Max
+	Max int `json:"max"`
+}
+
+func (c CostConfig) validate() error {
+	if c.Fixed < 0 || c.BodyBytesPerUnit < 0 || c.Max < 0 {
+		return errors.New("cost must not be negative")
+	}
+	if c.BodyBytesPerUnit > 0 && c.Max == 0 {
+		return errors.New("cost max is required with body_bytes_per_unit")
+	}
+	return nil
+}
+
+// RateLimitPolicyConfig is additional limit applied to the matched requests
+type RateLimitPolicyConfig struct {
+	Name      string
+	Match     MatchConfig
+	Algorithm string
+	Options   any
+	// ClientKey overrides global client key config, nil means the global one is used
+	ClientKey *ClientKeyConfig
+	Cost      CostConfig
+	// DryRun policy only logs and counts requests it would reject
+	DryRun bool
+}
+
+type rawRateLimitPolicyConfig struct {
+	Name      string           `json:"name"`
+	Match     MatchConfig      `json:"match"`
+	Algorithm string           `json:"algorithm"`
+	Options   json.RawMessage  `json:"options"`
+	ClientKey *ClientKeyConfig `json:"client_key"`
+	Cost      CostConfig       `json:"cost"`
+	DryRun    bool             `json:"dry_run"`
+}
+
+func (p *RateLimitPolicyConfig) UnmarshalJSON(data []byte) error {
+	var raw rawRateLimitPolicyConfig
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to unmarshal rate limit policy object: %w", err)
+	}
+	if raw.Name == "" {
+		return fmt.Errorf("rate limit policy name is required")
+	}
+
+	options, err := unmarshalRateLimitOptions(raw.Algorithm, raw.Options)
+	if err != nil {
+		return fmt.Errorf("rate limit policy %q: %w", raw.Name, err)
+	}
+	if err := raw.Cost.validate(); err != nil {
+		return fmt.Errorf("rate limit policy %q: %w", raw.Name, err)
+	}
+
+	p.Name = raw.Name
+	p.Match = raw.Match
+	p.Algorithm = raw.Algorithm
+	p.Options = options
+	p.ClientKey = raw.ClientKey
+	p.Cost = raw.Cost
+	p.DryRun = raw.DryRun
+	return nil
+}
+
+// RedisTokenBucketLimiterOptions configures token bucket shared by all balancer replicas
+// through Redis-compatible store
+type RedisTokenBucketLimiterOptions struct {
+	Address          string     `json:"address"`
+	Password         string     `json:"password"`
+	DB               int        `json:"db"`
+	KeyPrefix        string     `json:"key_prefix"`
+	Capacity         int        `json:"capacity"`
+	RefillIntervalMS DurationMs `json:"refill_interval_ms"`
+	TimeoutMS        DurationMs `json:"timeout_ms"`
+	// Mode is sync (every request goes to the store) or local_cache
+	// (requests are counted locally and synchronized with the store every SyncIntervalMS)
+	Mode           string     `json:"mode"`
+	SyncIntervalMS DurationMs `json:"sync_interval_ms"`
+	// FailOpen allows requests when the store is unreachable, otherwise they are rejected
+	FailOpen bool `json:"fail_open"`
+}
+
+// ConcurrencyConfig limits simultaneous requests of a client, clients are identified by the global client key
+type ConcurrencyConfig struct {
+	// MaxInFlight is max number of requests of one client processed at the same time, 0 disables the limit
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+// QuotaConfig limits number of requests of a client per calendar period
+type QuotaConfig struct {
+	Limit int `json:"limit"`
+	// Period is day or month
+	Period string `json:"period"`
+	// Timezone of period boundaries in IANA format (e.g. Europe/Moscow), by default UTC
+	Timezone string `json:"timezone"`
+	// StorePath is file where counters are saved, empty path means they are lost on restart
+	StorePath       string     `json:"store_path"`
+	FlushIntervalMS DurationMs `json:"flush_interval_ms"`
+	// MaxClients is cap on tracked counters, over it the least recently used one is dropped
+	MaxClients int `json:"max_clients"`
+}
+
+// PeersConfig makes rate limits global for the static group of balancer replicas without external store:
+// every client key is owned by one replica chosen by consistent hashing
+type PeersConfig struct {
+	// Self is address of this replica from Addresses, other replicas send hits there
+	Self string `json:"self"`
+	// Listen is address of the peer listener, by default Self
+	Listen    string   `json:"listen"`
+	Addresses []string `json:"addresses"`
+	// Secret is shared by all replicas, requests of the peer listener without it are rejected
+	Secret string `json:"secret"`
+	// Mode is forward (every request of not owned key goes to the owner) or batch
+	// (requests are decided from the last known state and hits are sent every SyncIntervalMS)
+	Mode           string     `json:"mode"`
+	SyncIntervalMS DurationMs `json:"sync_interval_ms"`
+	TimeoutMS      DurationMs `json:"timeout_ms"`
+}
+
 type RateLimitConfig struct {
-	Algorithm string `json:"algorithm"`
-	Options   any    `json:"options"`
+	Algorithm       string                  `json:"algorithm"`
+	Options         any                     `json:"options"`
+	IdleTTLMS       DurationMs              `json:"idle_ttl_ms"`
+	SweepIntervalMS DurationMs              `json:"sweep_interval_ms"`
+	MaxClients      int                     `json:"max_clients"` // approximate: split evenly between registry shards, full shard evicts before the total reaches it
+	ClientKey       ClientKeyConfig         `json:"client_key"`
+	Cost            CostConfig              `json:"cost"`
+	Policies        []RateLimitPolicyConfig `json:"policies"`
+	Peers           *PeersConfig            `json:"peers"`
+	Concurrency     ConcurrencyConfig       `json:"concurrency"`
+	Quota           *QuotaConfig            `json:"quota"`
+	Plans           *PlansConfig            `json:"plans"`
+	DryRun          bool                    `json:"dry_run"`
 }
 
 type rawRateLimitConfig struct {
-	Algorithm string          `json:"algorithm"`
-	Options   json.RawMessage `json:"options"`
+	Algorithm       string                  `json:"algorithm"`
+	Options         json.RawMessage         `json:"options"`
+	IdleTTLMS       DurationMs              `json:"idle_ttl_ms"`
+	SweepIntervalMS DurationMs              `json:"sweep_interval_ms"`
+	MaxClients      int                     `json:"max_clients"`
+	ClientKey       ClientKeyConfig         `json:"client_key"`
+	Cost            CostConfig              `json:"cost"`
+	Policies        []RateLimitPolicyConfig `json:"policies"`
+	Peers           *PeersConfig            `json:"peers"`
+	Concurrency     ConcurrencyConfig       `json:"concurrency"`
+	Quota           *QuotaConfig            `json:"quota"`
+	Plans           *PlansConfig            `json:"plans"`
+	DryRun          bool                    `json:"dry_run"`
 }
 
 func (rl *RateLimitConfig) UnmarshalJSON(data []byte) error {
@@ -54,21 +384,69 @@ func (rl *RateLimitConfig) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("failed to unmarshal rate limiter object: %w", err)
 	}
 
-	switch raw.Algorithm {
-	case "token_bucket":
-		var cfg TokenBucketLimiterOptions
-		if err := json.Unmarshal(raw.Options, &cfg); err != nil {
-			return fmt.Errorf("failed to unmarshal rate limiter object: %w", err)
-		}
-		rl.Options = cfg
-	default:
-		return fmt.Errorf("unknown algorithm %q", raw.Algorithm)
+	options, err := unmarshalRateLimitOptions(raw.Algorithm, raw.Options)
+	if err != nil {
+		return err
+	}
+	if err := raw.Cost.validate(); err != nil {
+		return err
 	}
 
 	rl.Algorithm = raw.Algorithm
+	rl.Options = options
+	rl.IdleTTLMS = raw.IdleTTLMS
+	rl.SweepIntervalMS = raw.SweepIntervalMS
+	rl.MaxClients = raw.MaxClients
+	rl.ClientKey = raw.ClientKey
+	rl.Cost = raw.Cost
+	rl.Policies = raw.Policies
+	rl.Peers = raw.Peers
+	rl.Concurrency = raw.Concurrency
+	rl.Quota = raw.Quota
+	rl.Plans = raw.Plans
+	rl.DryRun = raw.DryRun
 	return nil
 }
 
+// unmarshalRateLimitOptions decodes options into the struct of the given algorithm
+func unmarshalRateLimitOptions(algorithm string, raw json.RawMessage) (any, error) {
+	switch algorithm {
+	case "token_bucket":
+		return unmarshalOptions[TokenBucketLimiterOptions](raw)
+	case "sliding_window_log":
+		return unmarshalOptions[SlidingWindowLogLimiterOptions](raw)
+	case "sliding_window_counter":
+		return unmarshalOptions[SlidingWindowCounterLimiterOptions](raw)
+	case "gcra":
+		return unmarshalOptions[GCRALimiterOptions](raw)
+	case "redis_token_bucket":
+		return unmarshalOptions[RedisTokenBucketLimiterOptions](raw)
+	case "plan":
+		// Limits are taken from plans config
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+}
+
+// optionsValidator is implemented by options which can be decoded, but still have invalid values
+type optionsValidator interface {
+	validate() error
+}
+
+func unmarshalOptions[T any](raw json.RawMessage) (any, error) {
+	var cfg T
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rate limiter object: %w", err)
+	}
+	if v, ok := any(cfg).(optionsValidator); ok {
+		if err := v.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rate limiter options: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
 type BackendConfig struct {
 	URL *url.URL
 }
@@ -90,11 +468,154 @@ func (b *BackendConfig) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// AccessListEntries are client keys (as returned by client key extractors) and networks
+type AccessListEntries struct {
+	Keys  []string `json:"keys"`
+	CIDRs CIDRList `json:"cidrs"`
+}
+
+// AccessLists are checked before rate limits: allowed clients bypass all limits, denied ones are rejected
+type AccessLists struct {
+	Allow AccessListEntries `json:"allow"`
+	Deny  AccessListEntries `json:"deny"`
+}
+
+type AccessListConfig struct {
+	AccessLists
+	// Path of JSON file with allow and deny lists, it replaces inline lists,
+	// is reloaded on change and updated through admin API
+	Path             string     `json:"path"`
+	ReloadIntervalMS DurationMs `json:"reload_interval_ms"`
+}
+
+// AdminConfig is listener of the management API, it is disabled when port is not set
+type AdminConfig struct {
+	Host string `json:"host"`
+	Port uint16 `json:"port"`
+}
+
+// UpstreamConfig is named pool of backends with its own balancing algorithm and health checks
+type UpstreamConfig struct {
+	Backends []BackendConfig    `json:"backends"`
+	Balancer LoadBalancerConfig `json:"balancer"`
+}
+
+// RouteConfig sends matched requests to the upstream pool or splits them between pools,
+// routes are checked in order
+type RouteConfig struct {
+	Name     string       `json:"name"`
+	Match    MatchConfig  `json:"match"`
+	Upstream string       `json:"upstream"`
+	Split    *SplitConfig `json:"split"`
+	// Mirror sends copies of requests to the shadow pool
+	Mirror          *MirrorConfig     `json:"mirror"`
+	RequestHeaders  HeaderRulesConfig `json:"request_headers"`
+	ResponseHeaders HeaderRulesConfig `json:"response_headers"`
+	Rewrite         *RewriteConfig    `json:"rewrite"`
+	// Redirect and Response are answered by the balancer itself, such routes have no upstream
+	Redirect *RedirectConfig       `json:"redirect"`
+	Response *DirectResponseConfig `json:"response"`
+}
+
+// RedirectConfig answers with redirect, location can contain variables like https://{host}{request_uri}
+type RedirectConfig struct {
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+}
+
+// DirectResponseConfig answers with fixed response, e.g. robots.txt or maintenance page
+type DirectResponseConfig struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// RewriteConfig changes path and host before proxying. Path is changed in order:
+// prefix is stripped, regex is replaced, prefix is added, then it is joined with backend URL path.
+type RewriteConfig struct {
+	StripPrefix string `json:"strip_prefix"`
+	Regex       string `json:"regex"`
+	// Replacement can refer to regex capture groups: $1 or ${name}
+	Replacement string `json:"replacement"`
+	AddPrefix   string `json:"add_prefix"`
+	Host        string `json:"host"`
+}
+
+// HeaderRulesConfig removes, sets and adds headers, values can contain variables
+// {client_ip}, {backend_url}, {request_id}, {host}, {scheme}, {path}, {query} and {request_uri}
+type HeaderRulesConfig struct {
+	Add    map[string]string `json:"add"`
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+}
+
+// MirrorConfig describes fire-and-forget copies of route requests, responses of the shadow pool are discarded
+type MirrorConfig struct {
+	Upstream string `json:"upstream"`
+	// Percent of requests which are copied, (0, 100]
+	Percent      float64    `json:"percent"`
+	TimeoutMS    DurationMs `json:"timeout_ms"`
+	MaxInFlight  int        `json:"max_in_flight"`
+	MaxBodyBytes int64      `json:"max_body_bytes"`
+}
+
+// SplitConfig spreads requests across pools by weights, e.g. 95 to stable and 5 to canary
+type SplitConfig struct {
+	Variants []SplitVariantConfig `json:"variants"`
+	// Header and cookie with upstream name force the variant
+	OverrideHeader string `json:"override_header"`
+	OverrideCookie string `json:"override_cookie"`
+	// Sticky sends all requests of the client (by client key) to the same variant
+	Sticky bool `json:"sticky"`
+}
+
+type SplitVariantConfig struct {
+	Upstream string `json:"upstream"`
+	Weight   int    `json:"weight"`
+}
+
+// TracingConfig sets where OpenTelemetry spans of requests are exported, empty exporter disables tracing
+type TracingConfig struct {
+	// Exporter is otlp (OTLP over HTTP), stdout or file
+	Exporter string `json:"exporter"`
+	// Endpoint is OTLP collector URL, e.g. http://collector:4318
+	Endpoint string `json:"endpoint"`
+	// Path is file of the file exporter
+	Path        string `json:"path"`
+	ServiceName string `json:"service_name"`
+	// SampleRatio is share of traces started by the balancer which are recorded, by default all of them
+	SampleRatio *float64 `json:"sample_ratio"`
+}
+
+// LogConfig sets level and format of the log
+type LogConfig struct {
+	// Level is debug, info (by default), warn or error
+	Level string `json:"level"`
+	// Format is text (by default) or json
+	Format   string             `json:"format"`
+	Sampling *LogSamplingConfig `json:"sampling"`
+}
+
+// LogSamplingConfig limits repeated records: every interval first Initial records with the same level and message
+// are written, then every Thereafter-th of them. Thereafter 1 writes all records.
+type LogSamplingConfig struct {
+	Initial    int        `json:"initial"`
+	Thereafter int        `json:"thereafter"`
+	IntervalMS DurationMs `json:"interval_ms"`
+}
+
 type Config struct {
 	Server       ServerConfig       `json:"server"`
+	Admin        AdminConfig        `json:"admin"`
+	AccessList   AccessListConfig   `json:"access_list"`
 	Backends     []BackendConfig    `json:"backends"`
 	LoadBalancer LoadBalancerConfig `json:"balancer"`
 	RateLimit    RateLimitConfig    `json:"rate_limit"`
+	// Upstreams and Routes are used together with Backends, which is pool of not routed requests
+	Upstreams map[string]UpstreamConfig `json:"upstreams"`
+	Routes    []RouteConfig             `json:"routes"`
+	Tracing   TracingConfig             `json:"tracing"`
+	Log       LogConfig                 `json:"log"`
 }
 
 func Load() (*Config, error) {
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitConfig_InvalidWindowOptions(t *testing.T) {
+	t.Parallel()
+	for _, algorithm := range []string{"sliding_window_log", "sliding_window_counter"} {
+		for _, options := range []string{`{"limit": 10}`, `{"limit": 10, "window_ms": -1}`, `{"limit": -1, "window_ms": 1000}`} {
+			var cfg RateLimitConfig
+			err := json.Unmarshal([]byte(`{"algorithm": "`+algorithm+`", "options": `+options+`}`), &cfg)
+			require.Error(t, err, "%s %s", algorithm, options)
+		}
+
+		var cfg RateLimitConfig
+		err := json.Unmarshal([]byte(`{"algorithm": "`+algorithm+`", "options": {"limit": 0, "window_ms": 1000}}`), &cfg)
+		require.NoError(t, err)
+	}
+}
+
+func TestRateLimitConfig_InvalidCost(t *testing.T) {
+	t.Parallel()
+	for _, cost := range []string{`{"body_bytes_per_unit": 1024}`, `{"fixed": -1}`, `{"max": -1}`} {
+		var cfg RateLimitConfig
+		err := json.Unmarshal([]byte(`{"algorithm": "token_bucket", "options": {}, "cost": `+cost+`}`), &cfg)
+		require.Error(t, err, cost)
+
+		var policy RateLimitPolicyConfig
+		err = json.Unmarshal([]byte(`{"name": "upload", "algorithm": "token_bucket", "options": {}, "cost": `+cost+`}`), &policy)
+		require.Error(t, err, cost)
+	}
+
+	var cfg RateLimitConfig
+	err := json.Unmarshal([]byte(`{"algorithm": "token_bucket", "options": {}, "cost": {"body_bytes_per_unit": 1024, "max": 100}}`), &cfg)
+	require.NoError(t, err)
+}
+
+func TestRateLimitConfig_InvalidRedisOptions(t *testing.T) {
+	t.Parallel()
+	for _, options := range []string{
+		`{"capacity": 10, "refill_interval_ms": 100}`,
+		`{"address": "redis:6379", "refill_interval_ms": 100}`,
+		`{"address": "redis:6379", "capacity": 10}`,
+		`{"address": "redis:6379", "capacity": 10, "refill_interval_ms": 100, "mode": "local-cache"}`,
+		`{"address": "redis:6379", "capacity": 10, "refill_interval_ms": 100, "timeout_ms": -1}`,
+	} {
+		var cfg RateLimitConfig
+		err := json.Unmarshal([]byte(`{"algorithm": "redis_token_bucket", "options": `+options+`}`), &cfg)
+		require.Error(t, err, options)
+	}
+
+	var cfg RateLimitConfig
+	err := json.Unmarshal([]byte(`{"algorithm": "redis_token_bucket", "options": `+
+		`{"address": "redis:6379", "capacity": 10, "refill_interval_ms": 100, "mode": "local_cache"}}`), &cfg)
+	require.NoError(t, err)
+}
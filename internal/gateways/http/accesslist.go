@@ -0,0 +1,246 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+)
+
+const defaultAccessListReloadInterval = 5 * time.Second
+
+type AccessVerdict int
+
+const (
+	// AccessDefault means client is not listed and goes through the limits
+	AccessDefault AccessVerdict = iota
+	AccessAllow
+	AccessDeny
+)
+
+// AccessList keeps allowed and denied clients. Lookups use immutable snapshot,
+// so they never wait for reloads or admin changes.
+type AccessList struct {
+	path string
+
+	// mu serializes changes of the lists
+	mu       sync.Mutex
+	lists    config.AccessLists
+	modTime  time.Time
+	snapshot atomic.Pointer[accessSnapshot]
+}
+
+type accessSnapshot struct {
+	allowKeys map[string]struct{}
+	denyKeys  map[string]struct{}
+	allowNets *cidrTrie
+	denyNets  *cidrTrie
+}
+
+func newAccessSnapshot(lists config.AccessLists) *accessSnapshot {
+	set := func(keys []string) map[string]struct{} {
+		m := make(map[string]struct{}, len(keys))
+		for _, k := range keys {
+			m[k] = struct{}{}
+		}
+		return m
+	}
+	return &accessSnapshot{
+		allowKeys: set(lists.Allow.Keys),
+		denyKeys:  set(lists.Deny.Keys),
+		allowNets: newCIDRTrie(lists.Allow.CIDRs),
+		denyNets:  newCIDRTrie(lists.Deny.CIDRs),
+	}
+}
+
+// NewAccessList creates lists from config, if file path is set lists are loaded from it
+func NewAccessList(cfg config.AccessListConfig) (*AccessList, error) {
+	l := &AccessList{path: cfg.Path, lists: cfg.AccessLists}
+	if l.path != "" {
+		if _, err := l.reload(); err != nil {
+			return nil, err
+		}
+	}
+	l.snapshot.Store(newAccessSnapshot(l.lists))
+	return l, nil
+}
+
+// Check returns verdict for the client, deny wins over allow.
+// Key or address can be invalid (zero value) if request does not have it.
+func (l *AccessList) Check(key string, addr netip.Addr) AccessVerdict {
+	s := l.snapshot.Load()
+	if _, ok := s.denyKeys[key]; ok && key != "" {
+		return AccessDeny
+	}
+	if addr.IsValid() && s.denyNets.Contains(addr) {
+		return AccessDeny
+	}
+	if _, ok := s.allowKeys[key]; ok && key != "" {
+		return AccessAllow
+	}
+	if addr.IsValid() && s.allowNets.Contains(addr) {
+		return AccessAllow
+	}
+	return AccessDefault
+}
+
+// Lists returns copy of the current lists
+func (l *AccessList) Lists() config.AccessLists {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return cloneAccessLists(l.lists)
+}
+
+func cloneAccessLists(lists config.AccessLists) config.AccessLists {
+	return config.AccessLists{
+		Allow: config.AccessListEntries{Keys: slices.Clone(lists.Allow.Keys), CIDRs: slices.Clone(lists.Allow.CIDRs)},
+		Deny:  config.AccessListEntries{Keys: slices.Clone(lists.Deny.Keys), CIDRs: slices.Clone(lists.Deny.CIDRs)},
+	}
+}
+
+// Update changes lists and saves them to the file, if it is set
+func (l *AccessList) Update(change func(lists *config.AccessLists)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lists := cloneAccessLists(l.lists)
+	change(&lists)
+	if l.path != "" {
+		if err := l.save(lists); err != nil {
+			return err
+		}
+	}
+	l.lists = lists
+	l.snapshot.Store(newAccessSnapshot(lists))
+	return nil
+}
+
+// save writes lists to the file atomically, l.mu must be held
+func (l *AccessList) save(lists config.AccessLists) error {
+	data, err := json.MarshalIndent(lists, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+	// Own write must not be reloaded
+	if info, err := os.Stat(l.path); err == nil {
+		l.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// reload reads lists from the file if it was changed since the last read, l.mu must be held.
+// Missing file means lists are not created yet, so the current ones are kept.
+func (l *AccessList) reload() (bool, error) {
+	info, err := os.Stat(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(l.modTime) {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return false, err
+	}
+	var lists config.AccessLists
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return false, fmt.Errorf("invalid access list file %s: %w", l.path, err)
+	}
+	l.lists = lists
+	l.modTime = info.ModTime()
+	return true, nil
+}
+
+// Watch reloads lists from the file when it is changed, reloads are logged with the logger of ctx
+func (l *AccessList) Watch(ctx context.Context, interval time.Duration) {
+	if l.path == "" {
+		return
+	}
+	if interval <= 0 {
+		interval = defaultAccessListReloadInterval
+	}
+	logger := logging.Component(logging.FromContext(ctx), "access_list")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			changed, err := l.reload()
+			if changed {
+				l.snapshot.Store(newAccessSnapshot(l.lists))
+			}
+			l.mu.Unlock()
+			if err != nil {
+				// Keep the last valid lists
+				logger.Warn("Failed to reload access list", logging.ErrorKey, err)
+			} else if changed {
+				logger.Info("Access list is reloaded", "path", l.path)
+			}
+		}
+	}
+}
+
+// AccessListMiddleware runs before all limits: allowed clients are passed to bypass handler,
+// denied ones are rejected with 403, the rest go to the next (limited) handler
+func AccessListMiddleware(
+	_ context.Context,
+	list *AccessList,
+	keys KeyExtractor,
+	trusted config.CIDRList,
+	bypass http.Handler,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, _ := keys.Extract(r)
+			addr, _ := ClientIP(r, trusted)
+			switch list.Check(key, addr) {
+			case AccessAllow:
+				bypass.ServeHTTP(w, r)
+			case AccessDeny:
+				logger := logging.FromContext(r.Context())
+				logger.InfoContext(r.Context(), "Request is denied by access list", logging.ClientIDKey, key, "client_ip", addr)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				if _, err := w.Write([]byte(`{"code":403,"message":"Access denied"}`)); err != nil {
+					logger.WarnContext(r.Context(), "Failed to return access denied answer", logging.ErrorKey, err)
+				}
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func TestAccessList_Check(t *testing.T) {
+	t.Parallel()
+	list, err := NewAccessList(config.AccessListConfig{AccessLists: config.AccessLists{
+		Allow: config.AccessListEntries{Keys: []string{"monitoring"}, CIDRs: mustCIDRs(t, "10.0.0.0/8")},
+		Deny:  config.AccessListEntries{Keys: []string{"abuser"}, CIDRs: mustCIDRs(t, "10.6.6.0/24", "2001:db8::/32")},
+	}})
+	require.NoError(t, err)
+
+	cases := []struct {
+		key  string
+		addr string
+		want AccessVerdict
+	}{
+		{"monitoring", "8.8.8.8", AccessAllow},
+		{"client", "10.1.2.3", AccessAllow},
+		{"client", "8.8.8.8", AccessDefault},
+		{"abuser", "10.1.2.3", AccessDeny},
+		{"monitoring", "10.6.6.6", AccessDeny},
+		{"", "2001:db8::1", AccessDeny},
+		{"", "", AccessDefault},
+	}
+	for _, tc := range cases {
+		var addr netip.Addr
+		if tc.addr != "" {
+			addr = netip.MustParseAddr(tc.addr)
+		}
+		require.Equal(t, tc.want, list.Check(tc.key, addr), "%s %s", tc.key, tc.addr)
+	}
+}
+
+func TestAccessList_File(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "access.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"allow":{"keys":["monitoring"]}}`), 0o644))
+
+	list, err := NewAccessList(config.AccessListConfig{
+		AccessLists: config.AccessLists{Allow: config.AccessListEntries{Keys: []string{"ignored"}}},
+		Path:        path,
+	})
+	require.NoError(t, err)
+	require.Equal(t, AccessAllow, list.Check("monitoring", netip.Addr{}))
+	require.Equal(t, AccessDefault, list.Check("ignored", netip.Addr{}), "file replaces inline lists")
+
+	// Changes through API are saved to the file
+	require.NoError(t, list.Update(func(lists *config.AccessLists) {
+		lists.Deny.Keys = append(lists.Deny.Keys, "abuser")
+	}))
+	require.Equal(t, AccessDeny, list.Check("abuser", netip.Addr{}))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "abuser")
+
+	// Changes of the file are reloaded
+	go list.Watch(t.Context(), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, os.WriteFile(path, []byte(`{"deny":{"cidrs":["192.0.2.0/24"]}}`), 0o644))
+	require.Eventually(t, func() bool {
+		return list.Check("", netip.MustParseAddr("192.0.2.1")) == AccessDeny
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, AccessDefault, list.Check("abuser", netip.Addr{}))
+
+	// Invalid file keeps the last valid lists
+	require.NoError(t, os.WriteFile(path, []byte(`{"deny":`), 0o644))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, AccessDeny, list.Check("", netip.MustParseAddr("192.0.2.1")))
+}
@@ -0,0 +1,306 @@
+package http
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/metrics"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+// AdminServer serves management API, it must not be exposed to clients
+type AdminServer struct {
+	host       string
+	port       uint16
+	mux        *http.ServeMux
+	httpServer *http.Server
+	logger     *slog.Logger
+}
+
+func NewAdminServer(options ...func(*AdminServer)) *AdminServer {
+	s := &AdminServer{
+		host: "127.0.0.1",
+		mux:  http.NewServeMux(),
+	}
+	for _, o := range options {
+		o(s)
+	}
+	s.logger = logging.Component(s.logger, "admin")
+	return s
+}
+
+func WithAdminHost(host string) func(*AdminServer) {
+	return func(s *AdminServer) {
+		if host != "" {
+			s.host = host
+		}
+	}
+}
+
+func WithAdminPort(port uint16) func(*AdminServer) {
+	return func(s *AdminServer) {
+		s.port = port
+	}
+}
+
+// WithAdminLogger sets logger of the admin server, by default it is slog.Default()
+func WithAdminLogger(logger *slog.Logger) func(*AdminServer) {
+	return func(s *AdminServer) {
+		s.logger = logger
+	}
+}
+
+// WithQuotaAdmin adds endpoints to inspect and reset client quotas:
+// GET /quotas/{key} and DELETE /quotas/{key}
+func WithQuotaAdmin(quota *ratelimit.Quota) func(*AdminServer) {
+	return func(s *AdminServer) {
+		s.mux.HandleFunc("GET /quotas/{key}", func(w http.ResponseWriter, r *http.Request) {
+			s.writeQuota(w, r.PathValue("key"), quota.Period(), quota.Usage(r.PathValue("key")))
+		})
+		s.mux.HandleFunc("DELETE /quotas/{key}", func(w http.ResponseWriter, r *http.Request) {
+			key := r.PathValue("key")
+			quota.Reset(key)
+			s.logger.Info("Quota is reset", logging.ClientIDKey, key)
+			s.writeQuota(w, key, quota.Period(), quota.Usage(key))
+		})
+	}
+}
+
+// WithPlansAdmin adds endpoints to inspect and change plans at runtime:
+// GET /plans and PUT /plans/{name}. New limits apply to live buckets of the plan clients immediately,
+// plans cannot be added at runtime because clients are bound to them by the config.
+func WithPlansAdmin(plans *ratelimit.Plans) func(*AdminServer) {
+	return func(s *AdminServer) {
+		s.mux.HandleFunc("GET /plans", func(w http.ResponseWriter, _ *http.Request) {
+			s.writeJSON(w, http.StatusOK, plans.Definitions())
+		})
+		s.mux.HandleFunc("PUT /plans/{name}", func(w http.ResponseWriter, r *http.Request) {
+			var limits config.PlanLimits
+			if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+				s.writeJSON(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": err.Error()})
+				return
+			}
+			name := r.PathValue("name")
+			if err := plans.SetPlan(name, limits); err != nil {
+				code := http.StatusBadRequest
+				if errors.Is(err, ratelimit.ErrUnknownPlan) {
+					code = http.StatusNotFound
+				}
+				s.writeJSON(w, code, map[string]any{"code": code, "message": err.Error()})
+				return
+			}
+			s.logger.Info("Plan is updated", "plan", name, "limits", limits)
+			s.writeJSON(w, http.StatusOK, limits)
+		})
+	}
+}
+
+// WithDryRunAdmin adds endpoint with requests which dry-run policies would reject: GET /ratelimit/dry-run
+func WithDryRunAdmin(stats *DryRunStats) func(*AdminServer) {
+	return func(s *AdminServer) {
+		s.mux.HandleFunc("GET /ratelimit/dry-run", func(w http.ResponseWriter, _ *http.Request) {
+			type entry struct {
+				Policy   string `json:"policy"`
+				Client   string `json:"client"`
+				Rejected uint64 `json:"rejected"`
+			}
+			snapshot := stats.Snapshot()
+			entries := make([]entry, 0, len(snapshot))
+			for k, v := range snapshot {
+				entries = append(entries, entry{Policy: k.Policy, Client: k.Client, Rejected: v})
+			}
+			slices.SortFunc(entries, func(a, b entry) int { return cmp.Compare(b.Rejected, a.Rejected) })
+			s.writeJSON(w, http.StatusOK, entries)
+		})
+	}
+}
+
+// WithAccessListAdmin adds endpoints to edit access lists, list is allow or deny:
+// GET /access-list, PUT and DELETE /access-list/{list}/keys/{key} and /access-list/{list}/cidrs/{cidr}
+func WithAccessListAdmin(accessList *AccessList) func(*AdminServer) {
+	return func(s *AdminServer) {
+		s.mux.HandleFunc("GET /access-list", func(w http.ResponseWriter, _ *http.Request) {
+			s.writeJSON(w, http.StatusOK, accessList.Lists())
+		})
+
+		update := func(w http.ResponseWriter, r *http.Request, change func(entries *config.AccessListEntries) error) {
+			var err error
+			updateErr := accessList.Update(func(lists *config.AccessLists) {
+				switch r.PathValue("list") {
+				case "allow":
+					err = change(&lists.Allow)
+				case "deny":
+					err = change(&lists.Deny)
+				default:
+					err = fmt.Errorf("unknown list %q, expected allow or deny", r.PathValue("list"))
+				}
+			})
+			if err != nil {
+				s.writeJSON(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": err.Error()})
+				return
+			}
+			if updateErr != nil {
+				s.writeJSON(w, http.StatusInternalServerError, map[string]any{
+					"code":    http.StatusInternalServerError,
+					"message": updateErr.Error(),
+				})
+				return
+			}
+			s.logger.Info("Access list is changed", "method", r.Method, "path", r.URL.Path)
+			s.writeJSON(w, http.StatusOK, accessList.Lists())
+		}
+
+		s.mux.HandleFunc("PUT /access-list/{list}/keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+			update(w, r, func(entries *config.AccessListEntries) error {
+				if !slices.Contains(entries.Keys, r.PathValue("key")) {
+					entries.Keys = append(entries.Keys, r.PathValue("key"))
+				}
+				return nil
+			})
+		})
+		s.mux.HandleFunc("DELETE /access-list/{list}/keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+			update(w, r, func(entries *config.AccessListEntries) error {
+				entries.Keys = slices.DeleteFunc(entries.Keys, func(k string) bool { return k == r.PathValue("key") })
+				return nil
+			})
+		})
+		// CIDR contains slash, so it takes the rest of the path
+		s.mux.HandleFunc("PUT /access-list/{list}/cidrs/{cidr...}", func(w http.ResponseWriter, r *http.Request) {
+			update(w, r, func(entries *config.AccessListEntries) error {
+				prefix, err := config.ParsePrefix(r.PathValue("cidr"))
+				if err != nil {
+					return err
+				}
+				if !slices.Contains(entries.CIDRs, prefix) {
+					entries.CIDRs = append(entries.CIDRs, prefix)
+				}
+				return nil
+			})
+		})
+		s.mux.HandleFunc("DELETE /access-list/{list}/cidrs/{cidr...}", func(w http.ResponseWriter, r *http.Request) {
+			update(w, r, func(entries *config.AccessListEntries) error {
+				prefix, err := config.ParsePrefix(r.PathValue("cidr"))
+				if err != nil {
+					return err
+				}
+				entries.CIDRs = slices.DeleteFunc(entries.CIDRs, func(p netip.Prefix) bool { return p == prefix })
+				return nil
+			})
+		})
+	}
+}
+
+// WithRoutesAdmin adds endpoints to inspect routes and change weights of traffic splits:
+// GET /routes and PUT /routes/{name}/weights with weights by upstream name, e.g. {"stable":95,"canary":5}
+func WithRoutesAdmin(routes []Route) func(*AdminServer) {
+	return func(s *AdminServer) {
+		type routeResponse struct {
+			Name     string         `json:"name"`
+			Upstream string         `json:"upstream,omitempty"`
+			Weights  map[string]int `json:"weights,omitempty"`
+		}
+		describe := func(route Route) routeResponse {
+			resp := routeResponse{Name: route.Name, Upstream: route.Upstream}
+			if route.Split != nil {
+				resp.Weights = route.Split.Weights()
+			}
+			return resp
+		}
+
+		s.mux.HandleFunc("GET /routes", func(w http.ResponseWriter, _ *http.Request) {
+			resp := make([]routeResponse, 0, len(routes))
+			for _, route := range routes {
+				resp = append(resp, describe(route))
+			}
+			s.writeJSON(w, http.StatusOK, resp)
+		})
+		s.mux.HandleFunc("PUT /routes/{name}/weights", func(w http.ResponseWriter, r *http.Request) {
+			i := slices.IndexFunc(routes, func(route Route) bool { return route.Name == r.PathValue("name") })
+			if i < 0 || routes[i].Split == nil {
+				s.writeJSON(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Split route not found"})
+				return
+			}
+			var weights map[string]int
+			if err := json.NewDecoder(r.Body).Decode(&weights); err != nil {
+				s.writeJSON(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": err.Error()})
+				return
+			}
+			if err := routes[i].Split.SetWeights(weights); err != nil {
+				s.writeJSON(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": err.Error()})
+				return
+			}
+			s.logger.Info("Weights of route are changed", "route", routes[i].Name, "weights", routes[i].Split.Weights())
+			s.writeJSON(w, http.StatusOK, describe(routes[i]))
+		})
+	}
+}
+
+// WithMetricsAdmin adds endpoint with metrics in Prometheus format: GET /metrics
+func WithMetricsAdmin(m *metrics.Metrics) func(*AdminServer) {
+	return func(s *AdminServer) {
+		s.mux.Handle("GET /metrics", m.Handler())
+	}
+}
+
+type quotaResponse struct {
+	Key       string    `json:"key"`
+	Period    string    `json:"period"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+func (s *AdminServer) writeQuota(w http.ResponseWriter, key, period string, decision ratelimit.QuotaDecision) {
+	s.writeJSON(w, http.StatusOK, quotaResponse{
+		Key:       key,
+		Period:    period,
+		Limit:     decision.Limit,
+		Used:      decision.Limit - decision.Remaining,
+		Remaining: decision.Remaining,
+		ResetAt:   decision.ResetAt,
+	})
+}
+
+func (s *AdminServer) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Warn("Failed to return admin answer", logging.ErrorKey, err)
+	}
+}
+
+func (s *AdminServer) Handler() http.Handler {
+	return s.mux
+}
+
+func (s *AdminServer) Run(_ context.Context) error {
+	addr := fmt.Sprintf("%s:%d", s.host, s.port)
+	s.httpServer = &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	s.logger.Info("Start admin server", "address", addr)
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *AdminServer) Shutdown(ctx context.Context) error {
+	if s.httpServer == nil {
+		return nil
+	}
+	return s.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"net/netip"
+)
+
+// cidrTrie is binary prefix tree of networks, lookup takes at most address length steps
+// regardless of number of networks. IPv4 and IPv6 networks are kept in separate trees.
+type cidrTrie struct {
+	v4 *cidrNode
+	v6 *cidrNode
+}
+
+type cidrNode struct {
+	children [2]*cidrNode
+	// terminal node is the last bit of some network, all addresses below it are contained
+	terminal bool
+}
+
+func newCIDRTrie(prefixes []netip.Prefix) *cidrTrie {
+	t := &cidrTrie{v4: &cidrNode{}, v6: &cidrNode{}}
+	for _, p := range prefixes {
+		t.insert(p)
+	}
+	return t
+}
+
+func (t *cidrTrie) root(addr netip.Addr) *cidrNode {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+func (t *cidrTrie) insert(p netip.Prefix) {
+	addr := p.Addr().Unmap()
+	bits := p.Bits()
+	if p.Addr().Is4In6() {
+		bits = max(bits-96, 0)
+	}
+
+	node := t.root(addr)
+	raw := addr.AsSlice()
+	for i := range bits {
+		if node.terminal {
+			// Wider network already contains this one
+			return
+		}
+		bit := addrBit(raw, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &cidrNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+	// Narrower networks are not needed anymore
+	node.children = [2]*cidrNode{}
+}
+
+// Contains reports whether addr belongs to any network of the trie
+func (t *cidrTrie) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	node := t.root(addr)
+	raw := addr.AsSlice()
+	for i := range addr.BitLen() {
+		if node.terminal {
+			return true
+		}
+		node = node.children[addrBit(raw, i)]
+		if node == nil {
+			return false
+		}
+	}
+	return node.terminal
+}
+
+func addrBit(raw []byte, i int) int {
+	return int(raw[i/8]>>(7-i%8)) & 1
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCIDRTrie(t *testing.T) {
+	t.Parallel()
+	trie := newCIDRTrie(mustCIDRs(t, "10.0.0.0/8", "192.168.1.7", "2001:db8::/32", "10.1.0.0/16", "::ffff:172.16.0.0/108"))
+
+	cases := map[string]bool{
+		"10.0.0.1":        true,
+		"10.255.255.255":  true,
+		"11.0.0.1":        false,
+		"192.168.1.7":     true,
+		"192.168.1.8":     false,
+		"::ffff:10.2.3.4": true,
+		"172.16.5.5":      true,
+		"172.32.0.1":      false,
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"::1":             false,
+	}
+	for raw, want := range cases {
+		require.Equal(t, want, trie.Contains(netip.MustParseAddr(raw)), raw)
+	}
+}
+
+func TestCIDRTrie_MatchAll(t *testing.T) {
+	t.Parallel()
+	trie := newCIDRTrie(mustCIDRs(t, "0.0.0.0/0"))
+	require.True(t, trie.Contains(netip.MustParseAddr("8.8.8.8")))
+	require.False(t, trie.Contains(netip.MustParseAddr("2001:db8::1")), "IPv4 networks do not contain IPv6 addresses")
+	require.False(t, newCIDRTrie(nil).Contains(netip.MustParseAddr("8.8.8.8")))
+}
+
+func BenchmarkCIDRTrie(b *testing.B) {
+	prefixes := make([]netip.Prefix, 0, 10_000)
+	for i := range 10_000 {
+		prefixes = append(prefixes, netip.PrefixFrom(netip.AddrFrom4([4]byte{byte(i >> 8), byte(i), 0, 0}), 24))
+	}
+	trie := newCIDRTrie(prefixes)
+	addr := netip.MustParseAddr("200.200.200.200")
+
+	for b.Loop() {
+		trie.Contains(addr)
+	}
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+// ClientIP returns address of the real client. X-Forwarded-For is taken into account
+// only when the direct peer is trusted proxy: the list is walked from right to left,
+// skipping trusted proxies, because only the entries appended by them can be trusted.
+func ClientIP(r *http.Request, trusted config.CIDRList) (netip.Addr, bool) {
+	peer, ok := remoteAddr(r)
+	if !ok || !trusted.Contains(peer) {
+		return peer, ok
+	}
+
+	forwarded := forwardedFor(r.Header)
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := forwarded[i]
+		if !trusted.Contains(addr) {
+			return addr, true
+		}
+		peer = addr
+	}
+	// Whole chain consists of trusted proxies, so the leftmost one is the client
+	return peer, true
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// forwardedFor parses all X-Forwarded-For headers, invalid entries are skipped
+func forwardedFor(h http.Header) []netip.Addr {
+	var addrs []netip.Addr
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, part := range strings.Split(value, ",") {
+			addr, err := netip.ParseAddr(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+// RequestCost calculates how many units of the limit request takes.
+// Zero value charges every request with one unit.
+type RequestCost struct {
+	Fixed int
+	// Header is trusted only for requests which came through TrustedProxies
+	Header           string
+	TrustedProxies   config.CIDRList
+	BodyBytesPerUnit int64
+	Max              int
+}
+
+func NewRequestCost(cfg config.CostConfig, trustedProxies config.CIDRList) RequestCost {
+	return RequestCost{
+		Fixed:            cfg.Fixed,
+		Header:           cfg.Header,
+		TrustedProxies:   trustedProxies,
+		BodyBytesPerUnit: cfg.BodyBytesPerUnit,
+		Max:              cfg.Max,
+	}
+}
+
+func (c RequestCost) Cost(r *http.Request) int {
+	cost := max(c.Fixed, 1)
+
+	if c.BodyBytesPerUnit > 0 {
+		switch {
+		case r.ContentLength >= 0:
+			units := (r.ContentLength + c.BodyBytesPerUnit - 1) / c.BodyBytesPerUnit
+			cost = max(cost, int(units))
+		default:
+			// Body size is unknown (chunked), charge as the largest request.
+			// Config requires Max with body cost, without it request could avoid the charge
+			cost = max(cost, c.Max)
+		}
+	}
+
+	if c.Header != "" {
+		if value, ok := c.headerCost(r); ok {
+			cost = value
+		}
+	}
+
+	if c.Max > 0 {
+		cost = min(cost, c.Max)
+	}
+	return cost
+}
+
+func (c RequestCost) headerCost(r *http.Request) (int, bool) {
+	raw := r.Header.Get(c.Header)
+	if raw == "" {
+		return 0, false
+	}
+	// Otherwise client could set cost of its own requests
+	peer, ok := remoteAddr(r)
+	if !ok || !c.TrustedProxies.Contains(peer) {
+		return 0, false
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 {
+		return 0, false
+	}
+	return value, true
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func TestRequestCost(t *testing.T) {
+	t.Parallel()
+	trusted := mustCIDRs(t, "10.0.0.0/8")
+
+	cases := []struct {
+		name    string
+		cost    config.CostConfig
+		prepare func(r *http.Request)
+		want    int
+	}{
+		{"default", config.CostConfig{}, func(*http.Request) {}, 1},
+		{"fixed", config.CostConfig{Fixed: 100}, func(*http.Request) {}, 100},
+		{"body size", config.CostConfig{BodyBytesPerUnit: 10}, func(r *http.Request) { r.ContentLength = 25 }, 3},
+		{"unknown body size", config.CostConfig{BodyBytesPerUnit: 10, Max: 50}, func(r *http.Request) { r.ContentLength = -1 }, 50},
+		{"max", config.CostConfig{BodyBytesPerUnit: 1, Max: 5}, func(r *http.Request) { r.ContentLength = 100 }, 5},
+		{"trusted header", config.CostConfig{Header: "X-Cost"}, func(r *http.Request) {
+			r.RemoteAddr = "10.0.0.1:1234"
+			r.Header.Set("X-Cost", "7")
+		}, 7},
+		{"untrusted header", config.CostConfig{Header: "X-Cost"}, func(r *http.Request) {
+			r.Header.Set("X-Cost", "7")
+		}, 1},
+		{"invalid header", config.CostConfig{Header: "X-Cost", Fixed: 2}, func(r *http.Request) {
+			r.RemoteAddr = "10.0.0.1:1234"
+			r.Header.Set("X-Cost", "-3")
+		}, 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(""))
+			tc.prepare(r)
+			require.Equal(t, tc.want, NewRequestCost(tc.cost, trusted).Cost(r))
+		})
+	}
+}
+
+func TestRequestCost_ChunkedBody(t *testing.T) {
+	t.Parallel()
+	cost := NewRequestCost(config.CostConfig{BodyBytesPerUnit: 1024, Max: 100}, nil)
+
+	// Reader of unknown size makes request chunked
+	r := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader("small")))
+	require.Equal(t, int64(-1), r.ContentLength)
+	require.Equal(t, 100, cost.Cost(r), "chunked body is charged as the largest request")
+}
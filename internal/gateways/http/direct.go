@@ -0,0 +1,81 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+)
+
+var redirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// Redirect answers with redirect to the templated location
+type Redirect struct {
+	status   int
+	location headerTemplate
+}
+
+func NewRedirect(cfg config.RedirectConfig) (*Redirect, error) {
+	status := cfg.Status
+	if status == 0 {
+		status = http.StatusFound
+	}
+	if !slices.Contains(redirectStatuses, status) {
+		return nil, fmt.Errorf("invalid redirect status %d", status)
+	}
+	if cfg.Location == "" {
+		return nil, errors.New("redirect location is required")
+	}
+	location, err := newHeaderTemplate(cfg.Location)
+	if err != nil {
+		return nil, fmt.Errorf("redirect location: %w", err)
+	}
+	return &Redirect{status: status, location: location}, nil
+}
+
+func (rd *Redirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Location", rd.location.render(NewHeaderVars(r, "")))
+	w.WriteHeader(rd.status)
+}
+
+// DirectResponse answers with fixed status, headers and body without calling backends
+type DirectResponse struct {
+	status  int
+	headers *HeaderRules
+	body    []byte
+}
+
+func NewDirectResponse(cfg config.DirectResponseConfig) (*DirectResponse, error) {
+	status := cfg.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 599 {
+		return nil, fmt.Errorf("invalid response status %d", status)
+	}
+	headers, err := NewHeaderRules(config.HeaderRulesConfig{Set: cfg.Headers})
+	if err != nil {
+		return nil, err
+	}
+	return &DirectResponse{status: status, headers: headers, body: []byte(cfg.Body)}, nil
+}
+
+func (d *DirectResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.headers.Apply(w.Header(), NewHeaderVars(r, ""))
+	if w.Header().Get("Content-Type") == "" && len(d.body) > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(d.status)
+	if _, err := w.Write(d.body); err != nil {
+		logging.FromContext(r.Context()).WarnContext(r.Context(), "Failed to return direct response", logging.ErrorKey, err)
+	}
+}
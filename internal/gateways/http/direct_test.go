@@ -0,0 +1,66 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func TestRedirect(t *testing.T) {
+	t.Parallel()
+	rd, err := NewRedirect(config.RedirectConfig{
+		Status:   http.StatusPermanentRedirect,
+		Location: "https://{host}{request_uri}",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	rd.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://api.example.com/orders/1?full=true", nil))
+	require.Equal(t, http.StatusPermanentRedirect, w.Code)
+	require.Equal(t, "https://api.example.com/orders/1?full=true", w.Header().Get("Location"))
+
+	// Host from trusted proxy is used
+	req := httptest.NewRequest(http.MethodGet, "http://10.0.0.5/docs", nil)
+	req.Header.Set("X-Forwarded-Host", "www.example.com")
+	w = httptest.NewRecorder()
+	rd.ServeHTTP(w, req)
+	require.Equal(t, "https://www.example.com/docs", w.Header().Get("Location"))
+
+	_, err = NewRedirect(config.RedirectConfig{Status: http.StatusOK, Location: "/"})
+	require.Error(t, err)
+	_, err = NewRedirect(config.RedirectConfig{})
+	require.Error(t, err)
+}
+
+func TestDirectResponse(t *testing.T) {
+	t.Parallel()
+	resp, err := NewDirectResponse(config.DirectResponseConfig{
+		Headers: map[string]string{"Cache-Control": "max-age=3600"},
+		Body:    "User-agent: *\nDisallow: /admin\n",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	resp.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "max-age=3600", w.Header().Get("Cache-Control"))
+	require.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Equal(t, "User-agent: *\nDisallow: /admin\n", w.Body.String())
+
+	resp, err = NewDirectResponse(config.DirectResponseConfig{
+		Status:  http.StatusGone,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    `{"message":"API v1 is removed"}`,
+	})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	resp.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+	require.Equal(t, http.StatusGone, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	_, err = NewDirectResponse(config.DirectResponseConfig{Status: 1000})
+	require.Error(t, err)
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"sync"
+)
+
+// maxDryRunEntries bounds memory of dry-run stats, the rest of clients are counted together
+const maxDryRunEntries = 10_000
+
+const (
+	// DryRunOtherClients is client ID of requests counted after the stats are full
+	DryRunOtherClients = "other"
+	// DryRunAnonymousClient is client ID of requests without client identity
+	DryRunAnonymousClient = "anonymous"
+)
+
+// DryRunKey is policy and client of the request which dry-run policy would reject
+type DryRunKey struct {
+	Policy string
+	Client string
+}
+
+// DryRunStats counts requests which would be rejected by policies in dry-run mode
+type DryRunStats struct {
+	mu     sync.Mutex
+	counts map[DryRunKey]uint64
+}
+
+func NewDryRunStats() *DryRunStats {
+	return &DryRunStats{counts: make(map[DryRunKey]uint64)}
+}
+
+// Add counts rejection of the client request, nil stats count nothing
+func (s *DryRunStats) Add(policy, client string) {
+	if s == nil {
+		return
+	}
+	key := DryRunKey{Policy: policy, Client: client}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.counts[key]; !ok && len(s.counts) >= maxDryRunEntries {
+		key.Client = DryRunOtherClients
+	}
+	s.counts[key]++
+}
+
+// Snapshot returns copy of the counters
+func (s *DryRunStats) Snapshot() map[DryRunKey]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make(map[DryRunKey]uint64, len(s.counts))
+	for k, v := range s.counts {
+		snapshot[k] = v
+	}
+	return snapshot
+}
@@ -0,0 +1,28 @@
+package http
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunStats_Bounded(t *testing.T) {
+	t.Parallel()
+	stats := NewDryRunStats()
+	for i := range maxDryRunEntries + 10 {
+		stats.Add("global", "client-"+strconv.Itoa(i))
+	}
+	stats.Add("global", "client-0")
+
+	snapshot := stats.Snapshot()
+	require.Len(t, snapshot, maxDryRunEntries+1)
+	require.Equal(t, uint64(2), snapshot[DryRunKey{Policy: "global", Client: "client-0"}], "known clients are still counted")
+	require.Equal(t, uint64(10), snapshot[DryRunKey{Policy: "global", Client: DryRunOtherClients}])
+}
+
+func TestDryRunStats_Nil(t *testing.T) {
+	t.Parallel()
+	var stats *DryRunStats
+	require.NotPanics(t, func() { stats.Add("global", "client") })
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+type clientIPContextKey struct{}
+
+// requestClientIP returns client address found by ForwardedHeadersMiddleware
+func requestClientIP(r *http.Request) string {
+	if addr, ok := r.Context().Value(clientIPContextKey{}).(netip.Addr); ok {
+		return addr.String()
+	}
+	if addr, ok := remoteAddr(r); ok {
+		return addr.String()
+	}
+	return ""
+}
+
+// ForwardedHeadersMiddleware tells backends the real client address, scheme and host:
+// X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host and RFC 7239 Forwarded.
+// Headers received from trusted proxies are extended, headers of other peers are replaced,
+// so clients cannot spoof them. X-Forwarded-For is appended by the reverse proxy itself.
+func ForwardedHeadersMiddleware(_ context.Context, trusted config.CIDRList) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if addr, ok := ClientIP(r, trusted); ok {
+				r = r.WithContext(context.WithValue(r.Context(), clientIPContextKey{}, addr))
+			}
+
+			peer, ok := remoteAddr(r)
+			fromTrusted := ok && trusted.Contains(peer)
+
+			proto := "http"
+			if r.TLS != nil {
+				proto = "https"
+			}
+			host := r.Host
+			if fromTrusted {
+				if value := r.Header.Get("X-Forwarded-Proto"); value != "" {
+					proto = value
+				}
+				if value := r.Header.Get("X-Forwarded-Host"); value != "" {
+					host = value
+				}
+			} else {
+				r.Header.Del("X-Forwarded-For")
+				r.Header.Del("Forwarded")
+			}
+			r.Header.Set("X-Forwarded-Proto", proto)
+			r.Header.Set("X-Forwarded-Host", host)
+
+			element := "proto=" + proto + ";host=" + quoteForwarded(r.Host)
+			if ok {
+				element = "for=" + forwardedNode(peer) + ";" + element
+			}
+			if prior := r.Header.Values("Forwarded"); len(prior) > 0 {
+				element = strings.Join(prior, ", ") + ", " + element
+			}
+			r.Header.Set("Forwarded", element)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedNode formats address for Forwarded header, IPv6 must be quoted and bracketed
+func forwardedNode(addr netip.Addr) string {
+	if addr.Is6() {
+		return `"[` + addr.String() + `]"`
+	}
+	return addr.String()
+}
+
+// quoteForwarded quotes value if it is not a token, e.g. host with port
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, ":[]\" ,;") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
@@ -0,0 +1,187 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/requestid"
+)
+
+// headerVariables are names which can be used in header values as {name}
+var headerVariables = []string{
+	"client_ip", "backend_url", "request_id", "host", "scheme", "path", "query", "request_uri",
+}
+
+// HeaderVars are values of the header variables for the request
+type HeaderVars struct {
+	ClientIP   string
+	BackendURL string
+	RequestID  string
+	// Host and Scheme are requested by the client, they take into account headers of trusted proxies
+	Host   string
+	Scheme string
+	// Path and Query are escaped, RequestURI is path with query
+	Path       string
+	Query      string
+	RequestURI string
+}
+
+// NewHeaderVars returns variables of the request, backend URL is empty if request is not proxied
+func NewHeaderVars(r *http.Request, backendURL string) HeaderVars {
+	vars := HeaderVars{
+		ClientIP:   requestClientIP(r),
+		BackendURL: backendURL,
+		RequestID:  requestid.FromContext(r.Context()),
+		Host:       r.Header.Get("X-Forwarded-Host"),
+		Scheme:     r.Header.Get("X-Forwarded-Proto"),
+		Path:       r.URL.EscapedPath(),
+		Query:      r.URL.RawQuery,
+		RequestURI: r.URL.RequestURI(),
+	}
+	// Headers are set by ForwardedHeadersMiddleware, without it request is taken as is
+	if vars.Host == "" {
+		vars.Host = r.Host
+	}
+	if vars.Scheme == "" {
+		vars.Scheme = "http"
+		if r.TLS != nil {
+			vars.Scheme = "https"
+		}
+	}
+	return vars
+}
+
+func (v HeaderVars) value(name string) string {
+	switch name {
+	case "client_ip":
+		return v.ClientIP
+	case "backend_url":
+		return v.BackendURL
+	case "request_id":
+		return v.RequestID
+	case "host":
+		return v.Host
+	case "scheme":
+		return v.Scheme
+	case "path":
+		return v.Path
+	case "query":
+		return v.Query
+	case "request_uri":
+		return v.RequestURI
+	}
+	return ""
+}
+
+// headerTemplate is header value split into literal parts and variables
+type headerTemplate struct {
+	parts []string
+	// vars[i] is true if parts[i] is variable name
+	vars []bool
+}
+
+func newHeaderTemplate(value string) (headerTemplate, error) {
+	var t headerTemplate
+	for value != "" {
+		start := strings.IndexByte(value, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(value[start:], '}')
+		if end < 0 {
+			break
+		}
+		name := value[start+1 : start+end]
+		if !slices.Contains(headerVariables, name) {
+			return headerTemplate{}, fmt.Errorf("unknown header variable {%s}", name)
+		}
+		if start > 0 {
+			t.parts, t.vars = append(t.parts, value[:start]), append(t.vars, false)
+		}
+		t.parts, t.vars = append(t.parts, name), append(t.vars, true)
+		value = value[start+end+1:]
+	}
+	if value != "" {
+		t.parts, t.vars = append(t.parts, value), append(t.vars, false)
+	}
+	return t, nil
+}
+
+func (t headerTemplate) render(vars HeaderVars) string {
+	if len(t.parts) == 1 && !t.vars[0] {
+		return t.parts[0]
+	}
+	var b strings.Builder
+	for i, part := range t.parts {
+		if t.vars[i] {
+			b.WriteString(vars.value(part))
+		} else {
+			b.WriteString(part)
+		}
+	}
+	return b.String()
+}
+
+type headerRule struct {
+	name  string
+	value headerTemplate
+}
+
+// HeaderRules removes, sets and adds headers, in this order
+type HeaderRules struct {
+	remove []string
+	set    []headerRule
+	add    []headerRule
+}
+
+// NewHeaderRules creates rules from config, nil is returned if there are no rules
+func NewHeaderRules(cfg config.HeaderRulesConfig) (*HeaderRules, error) {
+	if len(cfg.Add) == 0 && len(cfg.Set) == 0 && len(cfg.Remove) == 0 {
+		return nil, nil
+	}
+	rules := func(headers map[string]string) ([]headerRule, error) {
+		result := make([]headerRule, 0, len(headers))
+		for name, value := range headers {
+			t, err := newHeaderTemplate(value)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %w", name, err)
+			}
+			result = append(result, headerRule{name: http.CanonicalHeaderKey(name), value: t})
+		}
+		// Map order is random, but headers must be applied the same way every time
+		slices.SortFunc(result, func(a, b headerRule) int { return strings.Compare(a.name, b.name) })
+		return result, nil
+	}
+
+	h := &HeaderRules{}
+	for _, name := range cfg.Remove {
+		h.remove = append(h.remove, http.CanonicalHeaderKey(name))
+	}
+	var err error
+	if h.set, err = rules(cfg.Set); err != nil {
+		return nil, err
+	}
+	if h.add, err = rules(cfg.Add); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Apply changes headers, nil rules change nothing
+func (h *HeaderRules) Apply(header http.Header, vars HeaderVars) {
+	if h == nil {
+		return
+	}
+	for _, name := range h.remove {
+		header.Del(name)
+	}
+	for _, rule := range h.set {
+		header.Set(rule.name, rule.value.render(vars))
+	}
+	for _, rule := range h.add {
+		header.Add(rule.name, rule.value.render(vars))
+	}
+}
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func TestHeaderRules(t *testing.T) {
+	t.Parallel()
+	rules, err := NewHeaderRules(config.HeaderRulesConfig{
+		Add:    map[string]string{"x-via": "lb {client_ip}"},
+		Set:    map[string]string{"X-Backend": "{backend_url}", "X-Trace": "{request_id}@{host}"},
+		Remove: []string{"authorization"},
+	})
+	require.NoError(t, err)
+
+	header := http.Header{}
+	header.Set("Authorization", "secret")
+	header.Set("X-Backend", "old")
+	header.Set("X-Via", "proxy")
+	rules.Apply(header, HeaderVars{
+		ClientIP:   "203.0.113.5",
+		BackendURL: "http://10.0.0.1:8080",
+		RequestID:  "abc",
+		Host:       "api.example.com",
+	})
+
+	require.Empty(t, header.Get("Authorization"))
+	require.Equal(t, "http://10.0.0.1:8080", header.Get("X-Backend"))
+	require.Equal(t, "abc@api.example.com", header.Get("X-Trace"))
+	require.Equal(t, []string{"proxy", "lb 203.0.113.5"}, header.Values("X-Via"))
+}
+
+func TestHeaderRules_Invalid(t *testing.T) {
+	t.Parallel()
+	_, err := NewHeaderRules(config.HeaderRulesConfig{Set: map[string]string{"X-Test": "{unknown}"}})
+	require.Error(t, err)
+
+	rules, err := NewHeaderRules(config.HeaderRulesConfig{})
+	require.NoError(t, err)
+	require.Nil(t, rules, "empty config has no rules")
+	rules.Apply(http.Header{}, HeaderVars{})
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	t.Parallel()
+	var got http.Header
+	handler := ForwardedHeadersMiddleware(t.Context(), mustCIDRs(t, "10.0.0.0/8"))(
+		http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			got = r.Header.Clone()
+			require.Equal(t, "198.51.100.7", requestClientIP(r))
+		}),
+	)
+
+	// Client spoofs headers, they are replaced
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "evil.example.com")
+	req.Header.Set("Forwarded", "for=1.1.1.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	require.Empty(t, got.Get("X-Forwarded-For"), "reverse proxy appends the peer itself")
+	require.Equal(t, "http", got.Get("X-Forwarded-Proto"))
+	require.Equal(t, "api.example.com", got.Get("X-Forwarded-Host"))
+	require.Equal(t, "for=198.51.100.7;proto=http;host=api.example.com", got.Get("Forwarded"))
+
+	// Trusted proxy headers are kept and extended
+	req = httptest.NewRequest(http.MethodGet, "http://internal:8080/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "api.example.com")
+	req.Header.Set("Forwarded", `for=198.51.100.7;proto=https`)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	require.Equal(t, "198.51.100.7", got.Get("X-Forwarded-For"))
+	require.Equal(t, "https", got.Get("X-Forwarded-Proto"))
+	require.Equal(t, "api.example.com", got.Get("X-Forwarded-Host"))
+	require.Equal(t, `for=198.51.100.7;proto=https, for=10.0.0.1;proto=https;host="internal:8080"`, got.Get("Forwarded"))
+}
@@ -0,0 +1,216 @@
+package http
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+const defaultKeyHeader = "X-API-Key"
+
+// KeyExtractor gets client identity for rate limiting from request.
+// It returns false if request does not carry such identity.
+type KeyExtractor interface {
+	Extract(r *http.Request) (string, bool)
+}
+
+// HeaderKey uses request header value (e.g. API key). Key is prefixed with header name like keys of other
+// extractors, so client cannot pick value which collides with them (e.g. with IP key of another client).
+type HeaderKey struct {
+	Name string
+}
+
+func (k HeaderKey) Extract(r *http.Request) (string, bool) {
+	value := r.Header.Get(k.Name)
+	if value == "" {
+		return "", false
+	}
+	return "header:" + k.Name + ":" + value, true
+}
+
+// ClientIPKey uses address of the client, see ClientIP
+type ClientIPKey struct {
+	TrustedProxies config.CIDRList
+}
+
+func (k ClientIPKey) Extract(r *http.Request) (string, bool) {
+	addr, ok := ClientIP(r, k.TrustedProxies)
+	if !ok {
+		return "", false
+	}
+	return "ip:" + addr.String(), true
+}
+
+// JWTClaimKey uses claim of bearer JWT payload.
+// Signature is NOT verified, so it must be used only behind gateway which authenticates tokens.
+type JWTClaimKey struct {
+	Header string
+	Claim  string
+}
+
+func (k JWTClaimKey) Extract(r *http.Request) (string, bool) {
+	token := r.Header.Get(k.Header)
+	if len(token) > len("bearer ") && strings.EqualFold(token[:len("bearer ")], "bearer ") {
+		token = token[len("bearer "):]
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", false
+	}
+
+	var claims map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", false
+	}
+	raw, exists := claims[k.Claim]
+	if !exists {
+		return "", false
+	}
+	// Claims are usually strings (sub), but numeric ids are also common
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(raw, &number); err != nil {
+			return "", false
+		}
+		value = number.String()
+	}
+	if value == "" {
+		return "", false
+	}
+	return "jwt:" + k.Claim + ":" + value, true
+}
+
+// QueryKey uses value of query parameter
+type QueryKey struct {
+	Name string
+}
+
+func (k QueryKey) Extract(r *http.Request) (string, bool) {
+	value := r.URL.Query().Get(k.Name)
+	if value == "" {
+		return "", false
+	}
+	return "query:" + k.Name + ":" + value, true
+}
+
+// PathKey uses request path, it is useful only as part of composite key
+type PathKey struct{}
+
+func (PathKey) Extract(r *http.Request) (string, bool) {
+	return r.URL.Path, true
+}
+
+// MethodKey uses request method, it is useful only as part of composite key
+type MethodKey struct{}
+
+func (MethodKey) Extract(r *http.Request) (string, bool) {
+	return r.Method, true
+}
+
+// CompositeKey joins keys of all parts, e.g. API key + path. Every part must be present.
+type CompositeKey []KeyExtractor
+
+func (k CompositeKey) Extract(r *http.Request) (string, bool) {
+	values := make([]string, 0, len(k))
+	for _, part := range k {
+		value, ok := part.Extract(r)
+		if !ok {
+			return "", false
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, "|"), len(values) > 0
+}
+
+// KeyChain tries extractors in order and returns the first found key.
+// Anonymous extractor is used for requests without any key, nil means such requests are rejected.
+type KeyChain struct {
+	Extractors []KeyExtractor
+	Anonymous  KeyExtractor
+}
+
+func (c KeyChain) Extract(r *http.Request) (string, bool) {
+	for _, e := range c.Extractors {
+		if key, ok := e.Extract(r); ok {
+			return key, true
+		}
+	}
+	if c.Anonymous != nil {
+		return c.Anonymous.Extract(r)
+	}
+	return "", false
+}
+
+// DefaultKeyExtractor identifies clients by X-API-Key header and rejects anonymous ones
+func DefaultKeyExtractor() KeyExtractor {
+	return HeaderKey{Name: defaultKeyHeader}
+}
+
+// NewKeyExtractor builds extractor chain from config, empty config gives DefaultKeyExtractor
+func NewKeyExtractor(cfg config.ClientKeyConfig, trustedProxies config.CIDRList) (KeyExtractor, error) {
+	chain := KeyChain{}
+	for _, ec := range cfg.Extractors {
+		e, err := newKeyExtractor(ec, trustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		chain.Extractors = append(chain.Extractors, e)
+	}
+	if len(chain.Extractors) == 0 {
+		chain.Extractors = []KeyExtractor{DefaultKeyExtractor()}
+	}
+
+	switch cfg.Anonymous {
+	case "", "reject":
+	case "client_ip":
+		chain.Anonymous = ClientIPKey{TrustedProxies: trustedProxies}
+	default:
+		return nil, fmt.Errorf("unknown anonymous clients policy %q", cfg.Anonymous)
+	}
+	return chain, nil
+}
+
+func newKeyExtractor(cfg config.KeyExtractorConfig, trustedProxies config.CIDRList) (KeyExtractor, error) {
+	switch cfg.Type {
+	case "header":
+		return HeaderKey{Name: cmp.Or(cfg.Name, defaultKeyHeader)}, nil
+	case "client_ip":
+		return ClientIPKey{TrustedProxies: trustedProxies}, nil
+	case "jwt_claim":
+		return JWTClaimKey{Header: cmp.Or(cfg.Name, "Authorization"), Claim: cmp.Or(cfg.Claim, "sub")}, nil
+	case "query":
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("query key extractor requires name")
+		}
+		return QueryKey{Name: cfg.Name}, nil
+	case "path":
+		return PathKey{}, nil
+	case "method":
+		return MethodKey{}, nil
+	case "composite":
+		if len(cfg.Parts) == 0 {
+			return nil, fmt.Errorf("composite key extractor requires parts")
+		}
+		composite := make(CompositeKey, 0, len(cfg.Parts))
+		for _, pc := range cfg.Parts {
+			part, err := newKeyExtractor(pc, trustedProxies)
+			if err != nil {
+				return nil, err
+			}
+			composite = append(composite, part)
+		}
+		return composite, nil
+	default:
+		return nil, fmt.Errorf("unknown key extractor type %q", cfg.Type)
+	}
+}
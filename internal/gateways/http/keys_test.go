@@ -0,0 +1,122 @@
+package http
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func mustCIDRs(t *testing.T, raw ...string) config.CIDRList {
+	t.Helper()
+	list := make(config.CIDRList, 0, len(raw))
+	for _, r := range raw {
+		prefix, err := config.ParsePrefix(r)
+		require.NoError(t, err)
+		list = append(list, prefix)
+	}
+	return list
+}
+
+func TestClientIP_TrustedProxies(t *testing.T) {
+	t.Parallel()
+	trusted := mustCIDRs(t, "10.0.0.0/8", "::1")
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:1234", "", "203.0.113.5"},
+		{"untrusted peer spoofs header", "203.0.113.5:1234", "1.1.1.1", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"spoofed entry before real client", "10.0.0.1:1234", "1.1.1.1, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"only proxies", "10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"ipv6 proxy", "[::1]:1234", "2001:db8::1", "2001:db8::1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.xff != "" {
+				r.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			addr, ok := ClientIP(r, trusted)
+			require.True(t, ok)
+			require.Equal(t, tc.want, addr.String())
+		})
+	}
+}
+
+func TestKeyExtractor_Chain(t *testing.T) {
+	t.Parallel()
+	keys, err := NewKeyExtractor(config.ClientKeyConfig{
+		Extractors: []config.KeyExtractorConfig{
+			{Type: "header", Name: "X-API-Key"},
+			{Type: "jwt_claim", Claim: "sub"},
+			{Type: "composite", Parts: []config.KeyExtractorConfig{
+				{Type: "query", Name: "key"},
+				{Type: "path"},
+			}},
+		},
+		Anonymous: "client_ip",
+	}, nil)
+	require.NoError(t, err)
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-42","exp":1}`))
+
+	cases := []struct {
+		name    string
+		prepare func(r *http.Request)
+		want    string
+	}{
+		{"api key", func(r *http.Request) { r.Header.Set("X-API-Key", "client1") }, "header:X-API-Key:client1"},
+		{"jwt claim", func(r *http.Request) { r.Header.Set("Authorization", "Bearer h."+payload+".sig") }, "jwt:sub:user-42"},
+		{"composite", func(r *http.Request) { r.URL.RawQuery = "key=abc"; r.URL.Path = "/search" }, "query:key:abc|/search"},
+		{"anonymous by ip", func(r *http.Request) {}, "ip:192.0.2.1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			tc.prepare(r)
+			key, ok := keys.Extract(r)
+			require.True(t, ok)
+			require.Equal(t, tc.want, key)
+		})
+	}
+}
+
+func TestKeyExtractor_NoCollisions(t *testing.T) {
+	t.Parallel()
+	keys, err := NewKeyExtractor(config.ClientKeyConfig{Anonymous: "client_ip"}, nil)
+	require.NoError(t, err)
+
+	victim := httptest.NewRequest(http.MethodGet, "/", nil)
+	victimKey, ok := keys.Extract(victim)
+	require.True(t, ok)
+
+	attacker := httptest.NewRequest(http.MethodGet, "/", nil)
+	attacker.RemoteAddr = "198.51.100.7:1234"
+	attacker.Header.Set("X-API-Key", victimKey)
+	attackerKey, ok := keys.Extract(attacker)
+	require.True(t, ok)
+	require.NotEqual(t, victimKey, attackerKey, "API key must not collide with IP key of another client")
+}
+
+func TestKeyExtractor_RejectAnonymous(t *testing.T) {
+	t.Parallel()
+	keys, err := NewKeyExtractor(config.ClientKeyConfig{}, nil)
+	require.NoError(t, err)
+
+	_, ok := keys.Extract(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.False(t, ok)
+
+	_, err = NewKeyExtractor(config.ClientKeyConfig{
+		Extractors: []config.KeyExtractorConfig{{Type: "cookie"}},
+	}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+// RequestMatcher selects requests by host, path and method. Empty conditions match everything.
+type RequestMatcher struct {
+	// Host is exact host name or wildcard like *.example.com
+	Host       string
+	PathPrefix string
+	PathRegex  *regexp.Regexp
+	Methods    []string
+	// Headers must have exactly these values, empty value means header must be present
+	Headers map[string]string
+}
+
+func NewRequestMatcher(cfg config.MatchConfig) (RequestMatcher, error) {
+	m := RequestMatcher{
+		Host:       strings.ToLower(cfg.Host),
+		PathPrefix: cfg.PathPrefix,
+		Headers:    cfg.Headers,
+	}
+	if cfg.PathRegex != "" {
+		re, err := regexp.Compile(cfg.PathRegex)
+		if err != nil {
+			return RequestMatcher{}, fmt.Errorf("invalid path regex %q: %w", cfg.PathRegex, err)
+		}
+		m.PathRegex = re
+	}
+	for _, method := range cfg.Methods {
+		m.Methods = append(m.Methods, strings.ToUpper(method))
+	}
+	return m, nil
+}
+
+func (m RequestMatcher) Match(r *http.Request) bool {
+	if m.Host != "" && !matchHost(m.Host, requestHost(r)) {
+		return false
+	}
+	if m.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, m.PathPrefix) {
+		return false
+	}
+	if m.PathRegex != nil && !m.PathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(m.Methods) > 0 && !slices.Contains(m.Methods, r.Method) {
+		return false
+	}
+	for name, value := range m.Headers {
+		got, ok := r.Header[http.CanonicalHeaderKey(name)]
+		if !ok || (value != "" && !slices.Contains(got, value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestHost returns lower case host of request without port
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func matchHost(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/metrics"
+)
+
+// requestLabels are filled by router and proxy, so the request is counted by route and backend
+type requestLabels struct {
+	route   string
+	backend string
+}
+
+type requestLabelsKey struct{}
+
+func setRequestRoute(ctx context.Context, route string) {
+	if labels, ok := ctx.Value(requestLabelsKey{}).(*requestLabels); ok {
+		labels.route = route
+	}
+}
+
+func setRequestBackend(ctx context.Context, backend string) {
+	if labels, ok := ctx.Value(requestLabelsKey{}).(*requestLabels); ok {
+		labels.backend = backend
+	}
+}
+
+// MetricsMiddleware counts requests and their latency by route, backend and response status.
+// Requests rejected before routing have empty route and backend.
+func MetricsMiddleware(_ context.Context, m *metrics.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			labels := &requestLabels{}
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestLabelsKey{}, labels)))
+			m.ObserveRequest(labels.route, labels.backend, sw.status, time.Since(start))
+		})
+	}
+}
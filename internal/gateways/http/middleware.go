@@ -2,43 +2,248 @@ package http
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/metrics"
+	"github.com/zahartd/load_balancer/internal/models"
 	"github.com/zahartd/load_balancer/internal/ratelimit"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func RateLimitMiddleware(ctx context.Context, rl *ratelimit.RateLimiter) func(http.Handler) http.Handler {
+// RateLimitMiddleware checks request against every matching policy, it is rejected by the first exceeded one.
+// Units taken by the policies checked before the rejection are given back, so rejected request uses no budget.
+// Response headers describe the most restrictive of the applied limits.
+// Policies in dry-run mode never reject, would-be rejections are logged and counted in stats (can be nil).
+// Results of every checked policy are recorded to metrics (can be nil).
+func RateLimitMiddleware(
+	_ context.Context,
+	policies []RateLimitPolicy,
+	stats *DryRunStats,
+	m *metrics.Metrics,
+) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			clientID := r.Header.Get("X-API-Key")
-			if clientID == "" {
+			logger := logging.FromContext(r.Context())
+			ctx, span := tracer(r.Context()).Start(r.Context(), "ratelimit")
+			// finish ends span before the request goes further, so span covers only limits evaluation
+			finish := func(allowed bool, policy string) {
+				span.SetAttributes(attribute.Bool("ratelimit.allowed", allowed))
+				if policy != "" {
+					span.SetAttributes(attribute.String("ratelimit.policy", policy))
+				}
+				span.End()
+			}
+
+			var (
+				strictest models.RateLimitDecision
+				applied   bool
+				charged   []rateLimitCharge
+			)
+			refund := func() {
+				for _, c := range charged {
+					c.limiter.RefundN(c.key, c.cost)
+				}
+			}
+			for _, policy := range policies {
+				if !policy.Match.Match(r) {
+					continue
+				}
+
+				clientID, ok := policy.Keys.Extract(r)
+				if !ok && policy.DryRun {
+					logger.InfoContext(r.Context(), "Dry-run policy would reject request without client identity",
+						"policy", policy.Name)
+					stats.Add(policy.Name, DryRunAnonymousClient)
+					m.ObserveRateLimit(policy.Name, metrics.ResultDryRunRejected)
+					continue
+				}
+				if !ok {
+					refund()
+					finish(false, policy.Name)
+					m.ObserveRateLimit(policy.Name, metrics.ResultRejected)
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					_, err := w.Write([]byte(`{"code":400,"message":"Client identity is required"}`))
+					if err != nil {
+						logger.WarnContext(r.Context(), "Failed to return response", logging.ErrorKey, err)
+					}
+					return
+				}
+
+				cost := policy.Cost.Cost(r)
+				var (
+					decision models.RateLimitDecision
+					err      error
+				)
+				if policy.DryRun {
+					// Dry-run policy only observes traffic, so shaping delay is not applied
+					decision = policy.Limiter.DecideN(ctx, clientID, cost)
+					if decision.Allowed && decision.Delay > 0 {
+						logger.DebugContext(r.Context(), "Dry-run policy would delay request",
+							logging.ClientIDKey, clientID, "policy", policy.Name, "delay", decision.Delay)
+					}
+				} else {
+					decision, err = policy.Limiter.AllowRequestN(ctx, clientID, cost)
+				}
+				if err != nil {
+					refund()
+					if errors.Is(err, context.Canceled) {
+						// Client gave up while request was delayed, there is nobody to answer
+						finish(false, policy.Name)
+						return
+					}
+					span.RecordError(err)
+					finish(false, policy.Name)
+					m.ObserveRateLimit(policy.Name, metrics.ResultRejected)
+					logger.ErrorContext(r.Context(), "Failed to check rate limit",
+						logging.ClientIDKey, clientID, "policy", policy.Name, logging.ErrorKey, err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				if !decision.Allowed && policy.DryRun {
+					logger.InfoContext(r.Context(), "Dry-run policy would reject request",
+						logging.ClientIDKey, clientID, "policy", policy.Name)
+					stats.Add(policy.Name, clientID)
+					m.ObserveRateLimit(policy.Name, metrics.ResultDryRunRejected)
+					continue
+				}
+				if !decision.Allowed {
+					refund()
+					finish(false, policy.Name)
+					m.ObserveRateLimit(policy.Name, metrics.ResultRejected)
+					logger.InfoContext(r.Context(), "Request is rejected by rate limit",
+						logging.ClientIDKey, clientID, "policy", policy.Name)
+					setRateLimitHeaders(w.Header(), decision)
+					w.Header().Set("Retry-After", headerSeconds(decision.RetryAfter))
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusTooManyRequests)
+					_, err := w.Write([]byte(`{"code":429,"message":"Rate limit exceeded"}`))
+					if err != nil {
+						logger.WarnContext(r.Context(), "Failed to return rate limit exceed answer", logging.ErrorKey, err)
+					}
+					return
+				}
+				logger.DebugContext(r.Context(), "Request is allowed by rate limit",
+					logging.ClientIDKey, clientID, "policy", policy.Name, "remaining", decision.Remaining)
+				m.ObserveRateLimit(policy.Name, metrics.ResultAllowed)
+				charged = append(charged, rateLimitCharge{limiter: policy.Limiter, key: clientID, cost: cost})
+
+				// Unlimited plan has no limit to report, dry-run limits are not announced to clients
+				if decision.Limit == 0 || policy.DryRun {
+					continue
+				}
+				if !applied || decision.Remaining < strictest.Remaining {
+					strictest = decision
+					applied = true
+				}
+			}
+
+			if applied {
+				setRateLimitHeaders(w.Header(), strictest)
+			}
+			finish(true, "")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitCharge is units taken from the client bucket by the allowed policy check
+type rateLimitCharge struct {
+	limiter *ratelimit.RateLimiter
+	key     string
+	cost    int
+}
+
+// ConcurrencyLimitMiddleware caps number of simultaneous requests of the client.
+// Slot is released when the proxied response is finished or the client cancels the request.
+func ConcurrencyLimitMiddleware(
+	_ context.Context,
+	limiter *ratelimit.ConcurrencyLimiter,
+	keys KeyExtractor,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientID, ok := keys.Extract(r)
+			if !ok {
+				// Anonymous requests are handled by rate limit policies
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			release, ok := limiter.Acquire(clientID)
+			if !ok {
+				logger := logging.FromContext(r.Context())
+				logger.InfoContext(r.Context(), "Too many concurrent requests", logging.ClientIDKey, clientID)
 				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				_, err := w.Write([]byte(`{"code":400,"message":"Header X-API-Key is required"}`))
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, err := w.Write([]byte(`{"code":429,"message":"Too many concurrent requests"}`))
 				if err != nil {
-					log.Printf("Failed to return response: %s\n", err.Error())
+					logger.WarnContext(r.Context(), "Failed to return concurrency limit exceed answer", logging.ErrorKey, err)
 				}
 				return
 			}
+			// Proxy returns after the response is copied or aborted, deferred release covers panics too
+			defer release()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// QuotaMiddleware enforces long-window quota of the client. Only requests passed rate limits reach it,
+// so rejected requests are not counted.
+func QuotaMiddleware(_ context.Context, quota *ratelimit.Quota, keys KeyExtractor) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientID, ok := keys.Extract(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-			allowed, err := rl.AllowRequest(ctx, clientID)
-			if err != nil {
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			decision := quota.Consume(clientID, 1)
+			if decision.Unlimited {
+				next.ServeHTTP(w, r)
 				return
 			}
-			if !allowed {
-				log.Printf("Request from %s did not alloweded\n", clientID)
+			resetAfter := time.Until(decision.ResetAt)
+			w.Header().Set("X-Quota-Limit", strconv.Itoa(decision.Limit))
+			w.Header().Set("X-Quota-Remaining", strconv.Itoa(decision.Remaining))
+			w.Header().Set("X-Quota-Reset", headerSeconds(resetAfter))
+			if !decision.Allowed {
+				logger := logging.FromContext(r.Context())
+				logger.InfoContext(r.Context(), "Quota is exceeded", logging.ClientIDKey, clientID)
+				w.Header().Set("Retry-After", headerSeconds(resetAfter))
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusTooManyRequests)
-				_, err := w.Write([]byte(`{"code":429,"message":"Rate limit exceeded"}`))
-				if err != nil {
-					log.Printf("Failed to return rate limit exceed answer: %s\n", err.Error())
+				// Body differs from rate limit one, so clients can tell that retry makes no sense until reset
+				body := fmt.Sprintf(
+					`{"code":429,"message":"Quota exceeded","quota":{"limit":%d,"period":%q,"reset":%q}}`,
+					decision.Limit, quota.Period(), decision.ResetAt.Format(time.RFC3339),
+				)
+				if _, err := w.Write([]byte(body)); err != nil {
+					logger.WarnContext(r.Context(), "Failed to return quota exceed answer", logging.ErrorKey, err)
 				}
 				return
 			}
-			log.Printf("Request from %s alloweded\n", clientID)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// setRateLimitHeaders sets IETF RateLimit header fields, client SDKs implement backoff from them
+func setRateLimitHeaders(h http.Header, decision models.RateLimitDecision) {
+	h.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	h.Set("RateLimit-Reset", headerSeconds(decision.ResetAfter))
+}
+
+// headerSeconds formats duration as delta-seconds rounded up, so client never retries too early
+func headerSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+func newShapingPolicy(t *testing.T, name string) RateLimitPolicy {
+	t.Helper()
+	limiter, err := ratelimit.New(t.Context(), "gcra", config.GCRALimiterOptions{
+		Rate:       1,
+		PeriodMS:   config.DurationMs(time.Hour.Milliseconds()),
+		Burst:      1,
+		Shaping:    true,
+		MaxDelayMS: config.DurationMs(2 * time.Hour.Milliseconds()),
+	})
+	require.NoError(t, err)
+	return RateLimitPolicy{
+		Name:    name,
+		Limiter: limiter,
+		Keys:    DefaultKeyExtractor(),
+	}
+}
+
+func TestRateLimitMiddleware_ClientCancelWhileDelayed(t *testing.T) {
+	t.Parallel()
+	forwarded := 0
+	handler := RateLimitMiddleware(t.Context(), []RateLimitPolicy{newShapingPolicy(t, GlobalPolicyName)}, nil, nil)(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) { forwarded++ }),
+	)
+	do := func(ctx context.Context) *httptest.ResponseRecorder {
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		r.Header.Set("X-API-Key", "client")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, do(t.Context()).Code)
+	require.Equal(t, 1, forwarded)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	w := do(ctx)
+	require.Equal(t, 1, forwarded, "cancelled request is not forwarded")
+	require.False(t, w.Flushed)
+	require.Empty(t, w.Body.String(), "nothing is answered to the gone client")
+}
+
+func TestRateLimitMiddleware_DryRunDoesNotDelay(t *testing.T) {
+	t.Parallel()
+	policy := newShapingPolicy(t, "shaping")
+	policy.DryRun = true
+	forwarded := 0
+	handler := RateLimitMiddleware(t.Context(), []RateLimitPolicy{policy}, nil, nil)(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) { forwarded++ }),
+	)
+
+	start := time.Now()
+	for range 3 {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-API-Key", "client")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	require.Equal(t, 3, forwarded)
+	require.Less(t, time.Since(start), time.Second, "requests shaped by dry-run policy are not delayed")
+}
+
+func newTokenBucketPolicy(t *testing.T, name string, capacity int, match RequestMatcher) RateLimitPolicy {
+	t.Helper()
+	limiter, err := ratelimit.New(t.Context(), "token_bucket", config.TokenBucketLimiterOptions{
+		DefaultCapacity:         capacity,
+		DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	})
+	require.NoError(t, err)
+	return RateLimitPolicy{
+		Name:    name,
+		Match:   match,
+		Limiter: limiter,
+		Keys:    DefaultKeyExtractor(),
+	}
+}
+
+func TestRateLimitMiddleware_RefundsOnRejection(t *testing.T) {
+	t.Parallel()
+	policies := []RateLimitPolicy{
+		newTokenBucketPolicy(t, GlobalPolicyName, 10, RequestMatcher{}),
+		newTokenBucketPolicy(t, "api", 1, RequestMatcher{PathPrefix: "/api"}),
+	}
+	handler := RateLimitMiddleware(t.Context(), policies, nil, nil)(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+	)
+	do := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("X-API-Key", "client")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, do("/api").Code)
+	for range 5 {
+		require.Equal(t, http.StatusTooManyRequests, do("/api").Code)
+	}
+	w := do("/")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "8", w.Header().Get("RateLimit-Remaining"), "rejected requests do not use global budget")
+}
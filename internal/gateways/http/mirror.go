@@ -0,0 +1,183 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+)
+
+const (
+	defaultMirrorTimeout      = time.Second
+	defaultMirrorMaxInFlight  = 100
+	defaultMirrorMaxBodyBytes = 1 << 20
+)
+
+// Mirror sends copies of sampled requests to the shadow pool. Copies are fire-and-forget:
+// they have own timeout and concurrency cap and never delay the primary request.
+// Request body is not buffered before proxying, it is copied while streaming to the primary backend.
+type Mirror struct {
+	upstream     string
+	balancer     *balancer.LoadBalancer
+	percent      float64
+	timeout      time.Duration
+	maxBodyBytes int64
+	// slots limits copies in flight, request is not copied if there is no free slot
+	slots  chan struct{}
+	client *http.Client
+}
+
+func NewMirror(cfg config.MirrorConfig, upstreams map[string]*balancer.LoadBalancer) (*Mirror, error) {
+	lb, ok := upstreams[cfg.Upstream]
+	if !ok {
+		return nil, fmt.Errorf("unknown mirror upstream %q", cfg.Upstream)
+	}
+	if cfg.Percent <= 0 || cfg.Percent > 100 {
+		return nil, errors.New("mirror percent must be in (0, 100]")
+	}
+	m := &Mirror{
+		upstream:     cfg.Upstream,
+		balancer:     lb,
+		percent:      cfg.Percent,
+		timeout:      cfg.TimeoutMS.AsDuration(),
+		maxBodyBytes: cfg.MaxBodyBytes,
+		client:       &http.Client{},
+	}
+	if m.timeout <= 0 {
+		m.timeout = defaultMirrorTimeout
+	}
+	if m.maxBodyBytes <= 0 {
+		m.maxBodyBytes = defaultMirrorMaxBodyBytes
+	}
+	maxInFlight := cfg.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMirrorMaxInFlight
+	}
+	m.slots = make(chan struct{}, maxInFlight)
+	return m, nil
+}
+
+// Handler copies sampled requests to the shadow pool and passes them to the next handler
+func (m *Mirror) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.percent < 100 && rand.Float64()*100 >= m.percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case m.slots <- struct{}{}:
+		default:
+			// Shadow pool is slow, skip the copy instead of queueing
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Copy outlives the request, but keeps its values like request ID
+		shadow := r.Clone(context.WithoutCancel(r.Context()))
+		if r.Body == nil || r.Body == http.NoBody {
+			go func() {
+				defer func() { <-m.slots }()
+				m.send(shadow, nil)
+			}()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Body streams to the primary backend as usual and is copied on the way,
+		// the copy is sent when the primary request has read all of it
+		capture := &bodyCapture{ReadCloser: r.Body, limit: m.maxBodyBytes}
+		r.Body = capture
+		next.ServeHTTP(w, r)
+
+		body, ok := capture.result()
+		if !ok {
+			// Body is larger than the limit or was not read completely
+			<-m.slots
+			return
+		}
+		go func() {
+			defer func() { <-m.slots }()
+			m.send(shadow, body)
+		}()
+	})
+}
+
+// bodyCapture keeps copy of the body read through it, up to the limit
+type bodyCapture struct {
+	io.ReadCloser
+	limit int64
+
+	// Transport can still read the body after the handler returns
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	overflow bool
+	eof      bool
+}
+
+func (c *bodyCapture) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.overflow {
+		if int64(c.buf.Len()+n) > c.limit {
+			c.overflow = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p[:n])
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		c.eof = true
+	}
+	return n, err
+}
+
+// result returns the whole body if it was read completely and fits the limit
+func (c *bodyCapture) result() ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.overflow || !c.eof {
+		return nil, false
+	}
+	return bytes.Clone(c.buf.Bytes()), true
+}
+
+// send makes copy of the request to the shadow pool and discards the response
+func (m *Mirror) send(r *http.Request, body []byte) {
+	backend, err := m.balancer.NextBackend()
+	if err != nil {
+		return
+	}
+	defer backend.DecConns()
+
+	ctx, cancel := context.WithTimeout(r.Context(), m.timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+	r.RequestURI = ""
+	r.URL.Scheme = backend.URL.Scheme
+	r.URL.Host = backend.URL.Host
+	joinBackendPath(backend.URL, r.URL)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	if body == nil {
+		r.Body = http.NoBody
+	}
+
+	resp, err := m.client.Do(r)
+	if err != nil {
+		logging.FromContext(ctx).InfoContext(ctx, "Mirror request failed",
+			"upstream", m.upstream, logging.BackendKey, backend.URL.String(), logging.ErrorKey, err)
+		return
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+}
@@ -0,0 +1,20 @@
+package http
+
+import (
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+// GlobalPolicyName is the name of policy built from top level rate limit config, it matches every request
+const GlobalPolicyName = "global"
+
+// RateLimitPolicy is rate limit applied to the matched requests.
+// Every policy has its own buckets, so limits of different policies do not affect each other.
+type RateLimitPolicy struct {
+	Name    string
+	Match   RequestMatcher
+	Limiter *ratelimit.RateLimiter
+	Keys    KeyExtractor
+	Cost    RequestCost
+	// DryRun policy only logs and counts requests it would reject, they are still forwarded
+	DryRun bool
+}
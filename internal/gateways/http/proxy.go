@@ -4,60 +4,157 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"syscall"
 
 	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/logging"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func NewProxy(lb *balancer.LoadBalancer) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get backend for request
-		backend, err := lb.NextBackend()
-		if err != nil {
-			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-			return
-		}
+// Proxy sends requests to backends of the pool
+type Proxy struct {
+	lb              *balancer.LoadBalancer
+	requestHeaders  *HeaderRules
+	responseHeaders *HeaderRules
+}
+
+func NewProxy(lb *balancer.LoadBalancer, options ...func(*Proxy)) *Proxy {
+	p := &Proxy{lb: lb}
+	for _, o := range options {
+		o(p)
+	}
+	return p
+}
+
+// WithRequestHeaders sets rules applied to request headers before proxying
+func WithRequestHeaders(rules *HeaderRules) func(*Proxy) {
+	return func(p *Proxy) {
+		p.requestHeaders = rules
+	}
+}
 
-		defer backend.DecConns()
+// WithResponseHeaders sets rules applied to backend response headers
+func WithResponseHeaders(rules *HeaderRules) func(*Proxy) {
+	return func(p *Proxy) {
+		p.responseHeaders = rules
+	}
+}
 
-		proxy := httputil.NewSingleHostReverseProxy(backend.URL)
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	// Get backend for request
+	_, selectSpan := tracer(r.Context()).Start(r.Context(), "balancer.next_backend")
+	backend, err := p.lb.NextBackend()
+	if err != nil {
+		selectSpan.RecordError(err)
+		selectSpan.SetStatus(codes.Error, err.Error())
+		selectSpan.End()
+		logger.WarnContext(r.Context(), "No available backend", "method", r.Method, "path", r.URL.Path)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	selectSpan.SetAttributes(semconv.URLFull(backend.URL.String()))
+	selectSpan.End()
+	setRequestBackend(r.Context(), backend.URL.String())
 
-		// Processing next backend errors:
-		// reaching the backend or errors from ModifyResponse.
-		proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, e error) {
-			log.Printf("Backend %s return error: %s\n", backend.URL.String(), e.Error())
+	defer backend.DecConns()
 
-			if errors.Is(e, context.Canceled) {
-				log.Printf("client canceled: %v\n", err)
-				return
+	ctx, span := tracer(r.Context()).Start(r.Context(), "upstream "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLFull(backend.URL.String()),
+			semconv.ServerAddress(backend.URL.Host),
+		),
+	)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	proxy := httputil.NewSingleHostReverseProxy(backend.URL)
+	if backend.URL.Path != "" {
+		// Default joining adds trailing slash to the backend path for the root request
+		director := proxy.Director
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			u := *r.URL
+			joinBackendPath(backend.URL, &u)
+			req.URL.Path, req.URL.RawPath = u.Path, u.RawPath
+		}
+	}
+	if p.requestHeaders != nil || p.responseHeaders != nil {
+		vars := NewHeaderVars(r, backend.URL.String())
+		director := proxy.Director
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			p.requestHeaders.Apply(req.Header, vars)
+			// Host is not sent from headers, so rule for it changes the request host
+			if host := req.Header.Get("Host"); host != "" {
+				req.Host = host
+				req.Header.Del("Host")
 			}
+		}
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			p.responseHeaders.Apply(resp.Header, vars)
+			return nil
+		}
+	}
+
+	// Backend continues the trace of the request
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		propagation.TraceContext{}.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+	}
+	modifyResponse := proxy.ModifyResponse
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+		if modifyResponse != nil {
+			return modifyResponse(resp)
+		}
+		return nil
+	}
 
-			// handle hetwork error
-			var opErr *net.OpError
-			if errors.As(err, &opErr) {
-				// Transport/connection error
-				if opErr.Op == "dial" || opErr.Timeout() || errors.Is(opErr.Err, syscall.ECONNREFUSED) {
-					lb.MarkBackendStatus(backend.URL.String(), false)
-					http.Error(rw, "Bad gateway", http.StatusBadGateway)
-					return
-				}
-
-				// Read timeot
-				if opErr.Op == "read" && opErr.Timeout() {
-					lb.MarkBackendStatus(backend.URL.String(), false)
-					http.Error(rw, "Upstream timeout", http.StatusGatewayTimeout)
-					return
-				}
+	// Processing next backend errors:
+	// reaching the backend or errors from ModifyResponse.
+	proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, e error) {
+		span.RecordError(e)
+		span.SetStatus(codes.Error, e.Error())
+		if errors.Is(e, context.Canceled) {
+			logger.DebugContext(req.Context(), "Client canceled request", logging.BackendKey, backend.URL.String())
+			return
+		}
+		logger.WarnContext(req.Context(), "Backend returned error", logging.BackendKey, backend.URL.String(), logging.ErrorKey, e)
+
+		// handle hetwork error
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			// Transport/connection error
+			if opErr.Op == "dial" || opErr.Timeout() || errors.Is(opErr.Err, syscall.ECONNREFUSED) {
+				p.lb.MarkBackendStatus(backend.URL.String(), false)
+				http.Error(rw, "Bad gateway", http.StatusBadGateway)
+				return
 			}
 
-			lb.MarkBackendStatus(backend.URL.String(), false)
-			http.Error(rw, fmt.Sprintf("Backend error: %v", e), http.StatusBadGateway)
+			// Read timeot
+			if opErr.Op == "read" && opErr.Timeout() {
+				p.lb.MarkBackendStatus(backend.URL.String(), false)
+				http.Error(rw, "Upstream timeout", http.StatusGatewayTimeout)
+				return
+			}
 		}
 
-		proxy.ServeHTTP(w, r)
-	})
+		p.lb.MarkBackendStatus(backend.URL.String(), false)
+		http.Error(rw, fmt.Sprintf("Backend error: %v", e), http.StatusBadGateway)
+	}
+
+	proxy.ServeHTTP(w, r)
 }
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/requestid"
+)
+
+// RequestIDMiddleware gives every request an ID: it is forwarded to the backend, returned to the client
+// and written in log lines of the request. ID from X-Request-ID is accepted only from trusted proxies,
+// other clients get a new one, so they cannot break log correlation.
+func RequestIDMiddleware(_ context.Context, trusted config.CIDRList) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestid.Header)
+			peer, ok := remoteAddr(r)
+			if !ok || !trusted.Contains(peer) || !requestid.Valid(id) {
+				id = requestid.New()
+			}
+			r.Header.Set(requestid.Header, id)
+			w.Header().Set(requestid.Header, id)
+			next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+		})
+	}
+}
+
+// LoggerMiddleware passes logger of the server to handlers of the request, they take it with logging.FromContext
+func LoggerMiddleware(_ context.Context, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(logging.NewContext(r.Context(), logger)))
+		})
+	}
+}
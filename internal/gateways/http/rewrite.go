@@ -0,0 +1,112 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+// Rewrite changes path and host of the request before proxying
+type Rewrite struct {
+	stripPrefix string
+	regex       *regexp.Regexp
+	replacement string
+	addPrefix   string
+	host        string
+}
+
+func NewRewrite(cfg config.RewriteConfig) (*Rewrite, error) {
+	rw := &Rewrite{
+		stripPrefix: strings.TrimSuffix(cfg.StripPrefix, "/"),
+		replacement: cfg.Replacement,
+		addPrefix:   strings.TrimSuffix(cfg.AddPrefix, "/"),
+		host:        cfg.Host,
+	}
+	if cfg.StripPrefix != "" && !strings.HasPrefix(cfg.StripPrefix, "/") {
+		return nil, errors.New("strip prefix must start with /")
+	}
+	if cfg.AddPrefix != "" && !strings.HasPrefix(cfg.AddPrefix, "/") {
+		return nil, errors.New("add prefix must start with /")
+	}
+	if cfg.Regex != "" {
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite regex %q: %w", cfg.Regex, err)
+		}
+		rw.regex = re
+	}
+	return rw, nil
+}
+
+// Path returns rewritten escaped path
+func (rw *Rewrite) Path(path string) string {
+	if rw.stripPrefix != "" {
+		// Prefix is stripped only on segment boundary: /api/v1 does not strip /api/v10
+		if path == rw.stripPrefix {
+			path = "/"
+		} else if strings.HasPrefix(path, rw.stripPrefix+"/") {
+			path = path[len(rw.stripPrefix):]
+		}
+	}
+	if rw.regex != nil {
+		path = rw.regex.ReplaceAllString(path, rw.replacement)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if rw.addPrefix != "" {
+		if path == "/" {
+			path = rw.addPrefix
+		} else {
+			path = rw.addPrefix + path
+		}
+	}
+	return path
+}
+
+// Handler passes request with rewritten path and host to the next handler, original request is not changed
+func (rw *Rewrite) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rewritten := r.WithContext(r.Context())
+		u := *r.URL
+		setEscapedPath(&u, rw.Path(r.URL.EscapedPath()))
+		rewritten.URL = &u
+		if rw.host != "" {
+			rewritten.Host = rw.host
+		}
+		next.ServeHTTP(w, rewritten)
+	})
+}
+
+// setEscapedPath sets both decoded and raw path, so encoded characters like %2F are kept
+func setEscapedPath(u *url.URL, escaped string) {
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		path = escaped
+	}
+	u.Path = path
+	u.RawPath = escaped
+}
+
+// joinBackendPath joins path of the backend URL with the request path. Unlike the reverse proxy default,
+// root of the request is mapped to the backend path as it is configured: / on backend /base is /base, not /base/.
+func joinBackendPath(backend *url.URL, u *url.URL) {
+	base := strings.TrimSuffix(backend.EscapedPath(), "/")
+	if base == "" {
+		return
+	}
+	path := u.EscapedPath()
+	if path == "" || path == "/" {
+		setEscapedPath(u, backend.EscapedPath())
+		return
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	setEscapedPath(u, base+path)
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func TestRewrite_Path(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		cfg  config.RewriteConfig
+		path string
+		want string
+	}{
+		{"strip prefix", config.RewriteConfig{StripPrefix: "/api/v1"}, "/api/v1/users", "/users"},
+		{"strip prefix with slash", config.RewriteConfig{StripPrefix: "/api/v1/"}, "/api/v1/users", "/users"},
+		{"strip whole path", config.RewriteConfig{StripPrefix: "/api/v1"}, "/api/v1", "/"},
+		{"strip only segment", config.RewriteConfig{StripPrefix: "/api/v1"}, "/api/v10/users", "/api/v10/users"},
+		{"add prefix", config.RewriteConfig{AddPrefix: "/v2"}, "/users", "/v2/users"},
+		{"add prefix to root", config.RewriteConfig{AddPrefix: "/v2/"}, "/", "/v2"},
+		{"replace prefix", config.RewriteConfig{StripPrefix: "/api/v1", AddPrefix: "/internal"}, "/api/v1/users", "/internal/users"},
+		{
+			"regex with groups",
+			config.RewriteConfig{Regex: `^/users/(\d+)/orders$`, Replacement: "/orders/by-user/$1"},
+			"/users/42/orders",
+			"/orders/by-user/42",
+		},
+		{
+			"named group",
+			config.RewriteConfig{Regex: `^/v(?P<version>\d+)/(.*)$`, Replacement: "/${2}/v${version}"},
+			"/v3/items",
+			"/items/v3",
+		},
+		{"regex result without slash", config.RewriteConfig{Regex: `^/old/`, Replacement: ""}, "/old/page", "/page"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			rw, err := NewRewrite(tc.cfg)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, rw.Path(tc.path))
+		})
+	}
+
+	_, err := NewRewrite(config.RewriteConfig{Regex: "("})
+	require.Error(t, err)
+	_, err = NewRewrite(config.RewriteConfig{AddPrefix: "v2"})
+	require.Error(t, err)
+}
+
+func TestJoinBackendPath(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		backend string
+		path    string
+		want    string
+	}{
+		{"http://backend", "/users", "/users"},
+		{"http://backend/base", "/users", "/base/users"},
+		{"http://backend/base/", "/users", "/base/users"},
+		{"http://backend/base", "/", "/base"},
+		{"http://backend/base/", "/", "/base/"},
+		{"http://backend/base", "/a%2Fb", "/base/a%2Fb"},
+	}
+	for _, tc := range cases {
+		backend, err := url.Parse(tc.backend)
+		require.NoError(t, err)
+		u, err := url.Parse(tc.path)
+		require.NoError(t, err)
+		joinBackendPath(backend, u)
+		require.Equal(t, tc.want, u.EscapedPath(), "%s + %s", tc.backend, tc.path)
+	}
+}
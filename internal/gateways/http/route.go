@@ -0,0 +1,158 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Route sends matched requests to the upstream pool or splits them between pools,
+// redirect and direct response routes answer without backends
+type Route struct {
+	Name     string
+	Match    RequestMatcher
+	Upstream string
+	Balancer *balancer.LoadBalancer
+	Split    *TrafficSplit
+	Mirror   *Mirror
+	Rewrite  *Rewrite
+	// Redirect and Response are answered without backends
+	Redirect *Redirect
+	Response *DirectResponse
+	// RequestHeaders and ResponseHeaders change headers of proxied requests and backend responses
+	RequestHeaders  *HeaderRules
+	ResponseHeaders *HeaderRules
+}
+
+// NewRoutes creates routes from config, every route must refer to the existing upstream pools.
+// Keys identify clients of sticky splits.
+func NewRoutes(
+	cfg []config.RouteConfig,
+	upstreams map[string]*balancer.LoadBalancer,
+	keys KeyExtractor,
+) ([]Route, error) {
+	routes := make([]Route, 0, len(cfg))
+	for i, rc := range cfg {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		match, err := NewRequestMatcher(rc.Match)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", name, err)
+		}
+		route := Route{Name: name, Match: match, Upstream: rc.Upstream}
+		actions := 0
+		for _, set := range []bool{rc.Upstream != "", rc.Split != nil, rc.Redirect != nil, rc.Response != nil} {
+			if set {
+				actions++
+			}
+		}
+		switch {
+		case actions > 1:
+			return nil, fmt.Errorf("route %s: upstream, split, redirect and response are mutually exclusive", name)
+		case rc.Redirect != nil:
+			if route.Redirect, err = NewRedirect(*rc.Redirect); err != nil {
+				return nil, fmt.Errorf("route %s: %w", name, err)
+			}
+		case rc.Response != nil:
+			if route.Response, err = NewDirectResponse(*rc.Response); err != nil {
+				return nil, fmt.Errorf("route %s: %w", name, err)
+			}
+		case rc.Split != nil:
+			route.Split, err = NewTrafficSplit(*rc.Split, upstreams, keys)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", name, err)
+			}
+		default:
+			lb, ok := upstreams[rc.Upstream]
+			if !ok {
+				return nil, fmt.Errorf("route %s: unknown upstream %q", name, rc.Upstream)
+			}
+			route.Balancer = lb
+		}
+		if route.RequestHeaders, err = NewHeaderRules(rc.RequestHeaders); err != nil {
+			return nil, fmt.Errorf("route %s: request headers: %w", name, err)
+		}
+		if route.ResponseHeaders, err = NewHeaderRules(rc.ResponseHeaders); err != nil {
+			return nil, fmt.Errorf("route %s: response headers: %w", name, err)
+		}
+		if rc.Rewrite != nil {
+			if route.Rewrite, err = NewRewrite(*rc.Rewrite); err != nil {
+				return nil, fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if rc.Mirror != nil {
+			route.Mirror, err = NewMirror(*rc.Mirror, upstreams)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// NewRouter proxies request to the pool of the first matched route.
+// Requests without route go to fallback, nil fallback answers 404.
+func NewRouter(routes []Route, fallback http.Handler) http.Handler {
+	handlers := make([]http.Handler, len(routes))
+	for i, route := range routes {
+		proxyOf := func(lb *balancer.LoadBalancer) http.Handler {
+			return NewProxy(lb, WithRequestHeaders(route.RequestHeaders), WithResponseHeaders(route.ResponseHeaders))
+		}
+		switch {
+		case route.Redirect != nil:
+			handlers[i] = route.Redirect
+		case route.Response != nil:
+			handlers[i] = route.Response
+		case route.Split == nil:
+			handlers[i] = proxyOf(route.Balancer)
+		default:
+			variants := make([]http.Handler, len(route.Split.balancers))
+			for j, lb := range route.Split.balancers {
+				variants[j] = proxyOf(lb)
+			}
+			split := route.Split
+			handlers[i] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				variants[split.Pick(r)].ServeHTTP(w, r)
+			})
+		}
+		if route.Mirror != nil {
+			handlers[i] = route.Mirror.Handler(handlers[i])
+		}
+		if route.Rewrite != nil {
+			// Shadow pool gets rewritten request too
+			handlers[i] = route.Rewrite.Handler(handlers[i])
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, route := range routes {
+			if route.Match.Match(r) {
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Method + " " + route.Name)
+				span.SetAttributes(semconv.HTTPRoute(route.Name))
+				setRequestRoute(r.Context(), route.Name)
+				handlers[i].ServeHTTP(w, r)
+				return
+			}
+		}
+		if fallback != nil {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		logger := logging.FromContext(r.Context())
+		logger.InfoContext(r.Context(), "No route", "method", r.Method, "host", r.Host, "path", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		if _, err := w.Write([]byte(`{"code":404,"message":"No route"}`)); err != nil {
+			logger.WarnContext(r.Context(), "Failed to return no route answer", logging.ErrorKey, err)
+		}
+	})
+}
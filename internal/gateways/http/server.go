@@ -4,11 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/metrics"
 	"github.com/zahartd/load_balancer/internal/ratelimit"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Server struct {
@@ -18,25 +22,86 @@ type Server struct {
 	httpServer   *http.Server
 	loadBalancer *balancer.LoadBalancer
 	rateLimiter  *ratelimit.RateLimiter
+	keyExtractor KeyExtractor
+	requestCost  RequestCost
+	policies     []RateLimitPolicy
+	concurrency  *ratelimit.ConcurrencyLimiter
+	quota        *ratelimit.Quota
+	dryRun       bool
+	dryRunStats  *DryRunStats
+	accessList   *AccessList
+	trusted      config.CIDRList
+	routes       []Route
+	tracer       trace.TracerProvider
+	metrics      *metrics.Metrics
+	logger       *slog.Logger
 }
 
 func NewServer(ctx context.Context, lb *balancer.LoadBalancer, rl *ratelimit.RateLimiter, options ...func(*Server)) *Server {
 	s := &Server{
 		loadBalancer: lb,
 		rateLimiter:  rl,
+		keyExtractor: DefaultKeyExtractor(),
+		dryRunStats:  NewDryRunStats(),
 	}
 	for _, o := range options {
 		o(s)
 	}
+	s.logger = logging.Component(s.logger, "gateway")
 
-	proxyHandler := NewProxy(s.loadBalancer)
+	// Balancer of the server is pool of requests without route
+	var fallback http.Handler
+	if s.loadBalancer != nil {
+		fallback = NewProxy(s.loadBalancer)
+	}
+	proxy := fallback
+	if len(s.routes) > 0 || fallback == nil {
+		s.logger.Info("Use routes", "count", len(s.routes))
+		proxy = NewRouter(s.routes, fallback)
+	}
+	proxy = ForwardedHeadersMiddleware(ctx, s.trusted)(proxy)
+	var proxyHandler http.Handler = proxy
+	if s.quota != nil {
+		s.logger.Info("Use quota", "period", s.quota.Period())
+		proxyHandler = QuotaMiddleware(ctx, s.quota, s.keyExtractor)(proxyHandler)
+	}
 	if s.rateLimiter != nil {
-		log.Println("Use rate limiting")
-		s.handler = RateLimitMiddleware(ctx, s.rateLimiter)(proxyHandler)
+		global := RateLimitPolicy{
+			Name:    GlobalPolicyName,
+			Limiter: s.rateLimiter,
+			Keys:    s.keyExtractor,
+			Cost:    s.requestCost,
+			DryRun:  s.dryRun,
+		}
+		s.policies = append([]RateLimitPolicy{global}, s.policies...)
+	}
+	if len(s.policies) > 0 {
+		s.logger.Info("Use rate limiting", "policies", len(s.policies))
+		s.handler = RateLimitMiddleware(ctx, s.policies, s.dryRunStats, s.metrics)(proxyHandler)
 	} else {
-		log.Println("Setup without rate limiting")
+		s.logger.Info("Setup without rate limiting")
 		s.handler = proxyHandler
 	}
+	if s.concurrency != nil {
+		// Requests waiting for shaping delay are in flight too, so concurrency is checked first
+		s.logger.Info("Use concurrency limit", "max_in_flight", s.concurrency.Limit())
+		s.handler = ConcurrencyLimitMiddleware(ctx, s.concurrency, s.keyExtractor)(s.handler)
+	}
+	if s.accessList != nil {
+		s.logger.Info("Use access list")
+		s.handler = AccessListMiddleware(ctx, s.accessList, s.keyExtractor, s.trusted, proxy)(s.handler)
+	}
+	if s.tracer != nil {
+		s.logger.Info("Use tracing")
+		s.handler = TracingMiddleware(ctx, s.tracer, s.trusted)(s.handler)
+	}
+	if s.metrics != nil {
+		// Rejected requests are counted too, so metrics wrap all the limits
+		s.handler = MetricsMiddleware(ctx, s.metrics)(s.handler)
+	}
+	// ID is assigned first, so that all log lines and the span of the request have it
+	s.handler = RequestIDMiddleware(ctx, s.trusted)(s.handler)
+	s.handler = LoggerMiddleware(ctx, s.logger)(s.handler)
 
 	return s
 }
@@ -53,6 +118,99 @@ func WithPort(port uint16) func(*Server) {
 	}
 }
 
+// WithKeyExtractor sets how clients are identified for rate limiting
+func WithKeyExtractor(keys KeyExtractor) func(*Server) {
+	return func(s *Server) {
+		s.keyExtractor = keys
+	}
+}
+
+// WithRequestCost sets how many units of the global limit request takes
+func WithRequestCost(cost RequestCost) func(*Server) {
+	return func(s *Server) {
+		s.requestCost = cost
+	}
+}
+
+// WithRateLimitPolicies adds rate limit policies applied after the global one
+func WithRateLimitPolicies(policies ...RateLimitPolicy) func(*Server) {
+	return func(s *Server) {
+		s.policies = append(s.policies, policies...)
+	}
+}
+
+// WithConcurrencyLimiter caps simultaneous in-flight requests of every client
+func WithConcurrencyLimiter(limiter *ratelimit.ConcurrencyLimiter) func(*Server) {
+	return func(s *Server) {
+		s.concurrency = limiter
+	}
+}
+
+// WithQuota sets long-window quota checked after all rate limits
+func WithQuota(quota *ratelimit.Quota) func(*Server) {
+	return func(s *Server) {
+		s.quota = quota
+	}
+}
+
+// WithRateLimitDryRun enables dry-run mode of the global rate limit: requests over the limit
+// are logged and counted but still forwarded
+func WithRateLimitDryRun(enabled bool) func(*Server) {
+	return func(s *Server) {
+		s.dryRun = enabled
+	}
+}
+
+// WithDryRunStats sets where requests rejected by dry-run policies are counted
+func WithDryRunStats(stats *DryRunStats) func(*Server) {
+	return func(s *Server) {
+		s.dryRunStats = stats
+	}
+}
+
+// WithAccessList sets clients which bypass all limits or are always rejected
+func WithAccessList(list *AccessList) func(*Server) {
+	return func(s *Server) {
+		s.accessList = list
+	}
+}
+
+// WithTrustedProxies sets proxies whose forwarding headers are trusted: they are used to get client address
+// for access list and are extended instead of replaced before proxying
+func WithTrustedProxies(trusted config.CIDRList) func(*Server) {
+	return func(s *Server) {
+		s.trusted = trusted
+	}
+}
+
+// WithRoutes adds routes to named upstream pools, they are checked in order
+func WithRoutes(routes ...Route) func(*Server) {
+	return func(s *Server) {
+		s.routes = append(s.routes, routes...)
+	}
+}
+
+// WithTracerProvider enables tracing of requests with spans of the provider
+func WithTracerProvider(provider trace.TracerProvider) func(*Server) {
+	return func(s *Server) {
+		s.tracer = provider
+	}
+}
+
+// WithLogger sets logger of the server and handlers of its requests, by default it is slog.Default()
+func WithLogger(logger *slog.Logger) func(*Server) {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithMetrics enables metrics of requests and rate limit decisions
+func WithMetrics(m *metrics.Metrics) func(*Server) {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
 func (s *Server) Handler() http.Handler {
 	return s.handler
 }
@@ -63,7 +221,7 @@ func (s *Server) Run(_ context.Context) error {
 		Addr:    addr,
 		Handler: s.handler,
 	}
-	log.Printf("Start server on %s\n", addr)
+	s.logger.Info("Start server", "address", addr)
 	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+// TrafficSplit spreads requests of the route across upstream pools by weights.
+// Weights are changed at runtime, requests read immutable snapshot of them.
+type TrafficSplit struct {
+	upstreams      []string
+	balancers      []*balancer.LoadBalancer
+	overrideHeader string
+	overrideCookie string
+	sticky         bool
+	keys           KeyExtractor
+
+	// mu serializes changes of the weights
+	mu      sync.Mutex
+	weights atomic.Pointer[splitWeights]
+}
+
+type splitWeights struct {
+	weights []int
+	total   int
+}
+
+func newSplitWeights(weights []int) (*splitWeights, error) {
+	total := 0
+	for _, w := range weights {
+		if w < 0 {
+			return nil, errors.New("weight must not be negative")
+		}
+		total += w
+	}
+	if total == 0 {
+		return nil, errors.New("at least one weight must be positive")
+	}
+	return &splitWeights{weights: weights, total: total}, nil
+}
+
+// NewTrafficSplit creates split from config, keys identify clients of the sticky split
+func NewTrafficSplit(
+	cfg config.SplitConfig,
+	upstreams map[string]*balancer.LoadBalancer,
+	keys KeyExtractor,
+) (*TrafficSplit, error) {
+	if len(cfg.Variants) == 0 {
+		return nil, errors.New("split must have variants")
+	}
+	s := &TrafficSplit{
+		overrideHeader: cfg.OverrideHeader,
+		overrideCookie: cfg.OverrideCookie,
+		sticky:         cfg.Sticky,
+		keys:           keys,
+	}
+	weights := make([]int, 0, len(cfg.Variants))
+	for _, v := range cfg.Variants {
+		lb, ok := upstreams[v.Upstream]
+		if !ok {
+			return nil, fmt.Errorf("unknown upstream %q", v.Upstream)
+		}
+		if slices.Contains(s.upstreams, v.Upstream) {
+			return nil, fmt.Errorf("duplicate upstream %q", v.Upstream)
+		}
+		s.upstreams = append(s.upstreams, v.Upstream)
+		s.balancers = append(s.balancers, lb)
+		weights = append(weights, v.Weight)
+	}
+	w, err := newSplitWeights(weights)
+	if err != nil {
+		return nil, err
+	}
+	s.weights.Store(w)
+	return s, nil
+}
+
+// Pick returns index of the variant for the request
+func (s *TrafficSplit) Pick(r *http.Request) int {
+	if i, ok := s.override(r); ok {
+		return i
+	}
+
+	// Point of the sticky client is fixed, so when the last variant grows at the same total
+	// its clients stay on it and only new ones move there
+	w := s.weights.Load()
+	var point int
+	if key, ok := s.stickyKey(r); ok {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		point = int(h.Sum64() % uint64(w.total))
+	} else {
+		point = rand.IntN(w.total)
+	}
+	for i, weight := range w.weights {
+		if point < weight {
+			return i
+		}
+		point -= weight
+	}
+	return len(w.weights) - 1
+}
+
+// override returns variant forced by the request header or cookie with upstream name
+func (s *TrafficSplit) override(r *http.Request) (int, bool) {
+	var name string
+	if s.overrideHeader != "" {
+		name = r.Header.Get(s.overrideHeader)
+	}
+	if name == "" && s.overrideCookie != "" {
+		if cookie, err := r.Cookie(s.overrideCookie); err == nil {
+			name = cookie.Value
+		}
+	}
+	if name == "" {
+		return 0, false
+	}
+	i := slices.Index(s.upstreams, name)
+	return i, i >= 0
+}
+
+func (s *TrafficSplit) stickyKey(r *http.Request) (string, bool) {
+	if !s.sticky || s.keys == nil {
+		return "", false
+	}
+	return s.keys.Extract(r)
+}
+
+// Weights returns current weights by upstream name
+func (s *TrafficSplit) Weights() map[string]int {
+	w := s.weights.Load()
+	weights := make(map[string]int, len(s.upstreams))
+	for i, name := range s.upstreams {
+		weights[name] = w.weights[i]
+	}
+	return weights
+}
+
+// SetWeights changes weights of the given upstreams, the rest keep their weights
+func (s *TrafficSplit) SetWeights(weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := slices.Clone(s.weights.Load().weights)
+	for name, weight := range weights {
+		i := slices.Index(s.upstreams, name)
+		if i < 0 {
+			return fmt.Errorf("unknown upstream %q", name)
+		}
+		next[i] = weight
+	}
+	w, err := newSplitWeights(next)
+	if err != nil {
+		return err
+	}
+	s.weights.Store(w)
+	return nil
+}
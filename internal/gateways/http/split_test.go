@@ -0,0 +1,106 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+// Pick does not use pools, so they are not started
+var splitUpstreams = map[string]*balancer.LoadBalancer{"stable": nil, "canary": nil}
+
+func newTestSplit(t *testing.T, cfg config.SplitConfig) *TrafficSplit {
+	t.Helper()
+	if cfg.Variants == nil {
+		cfg.Variants = []config.SplitVariantConfig{{Upstream: "stable", Weight: 90}, {Upstream: "canary", Weight: 10}}
+	}
+	split, err := NewTrafficSplit(cfg, splitUpstreams, DefaultKeyExtractor())
+	require.NoError(t, err)
+	return split
+}
+
+func TestTrafficSplit_Weights(t *testing.T) {
+	t.Parallel()
+	split := newTestSplit(t, config.SplitConfig{})
+
+	canary := 0
+	for range 10000 {
+		if split.Pick(httptest.NewRequest(http.MethodGet, "/", nil)) == 1 {
+			canary++
+		}
+	}
+	require.InDelta(t, 1000, canary, 200)
+
+	require.NoError(t, split.SetWeights(map[string]int{"stable": 0, "canary": 100}))
+	require.Equal(t, 1, split.Pick(httptest.NewRequest(http.MethodGet, "/", nil)))
+	require.Equal(t, map[string]int{"stable": 0, "canary": 100}, split.Weights())
+}
+
+func TestTrafficSplit_Override(t *testing.T) {
+	t.Parallel()
+	split := newTestSplit(t, config.SplitConfig{
+		Variants:       []config.SplitVariantConfig{{Upstream: "stable", Weight: 100}, {Upstream: "canary", Weight: 0}},
+		OverrideHeader: "X-Variant",
+		OverrideCookie: "variant",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Variant", "canary")
+	require.Equal(t, 1, split.Pick(req))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "variant", Value: "canary"})
+	require.Equal(t, 1, split.Pick(req))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Variant", "unknown")
+	require.Equal(t, 0, split.Pick(req), "unknown variant is ignored")
+}
+
+func TestTrafficSplit_Sticky(t *testing.T) {
+	t.Parallel()
+	split := newTestSplit(t, config.SplitConfig{Sticky: true})
+
+	pick := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", key)
+		return split.Pick(req)
+	}
+	onCanary := make(map[string]bool)
+	for i := range 1000 {
+		key := "client-" + strconv.Itoa(i)
+		variant := pick(key)
+		for range 5 {
+			require.Equal(t, variant, pick(key), "client must stay on its variant")
+		}
+		onCanary[key] = variant == 1
+	}
+
+	// Growing canary keeps its clients
+	require.NoError(t, split.SetWeights(map[string]int{"stable": 50, "canary": 50}))
+	for key, canary := range onCanary {
+		if canary {
+			require.Equal(t, 1, pick(key))
+		}
+	}
+}
+
+func TestTrafficSplit_InvalidWeights(t *testing.T) {
+	t.Parallel()
+	split := newTestSplit(t, config.SplitConfig{})
+
+	require.Error(t, split.SetWeights(map[string]int{"unknown": 10}))
+	require.Error(t, split.SetWeights(map[string]int{"canary": -1}))
+	require.Error(t, split.SetWeights(map[string]int{"stable": 0, "canary": 0}))
+	require.Equal(t, map[string]int{"stable": 90, "canary": 10}, split.Weights(), "invalid change is not applied")
+
+	_, err := NewTrafficSplit(config.SplitConfig{
+		Variants: []config.SplitVariantConfig{{Upstream: "stable", Weight: 1}, {Upstream: "stable", Weight: 1}},
+	}, splitUpstreams, nil)
+	require.Error(t, err)
+}
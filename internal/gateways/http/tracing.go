@@ -0,0 +1,88 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/requestid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/zahartd/load_balancer/internal/gateways/http"
+
+// tracer returns tracer of the provider which started the request span, it is noop if tracing is disabled
+func tracer(ctx context.Context) trace.Tracer {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+}
+
+// TracingMiddleware starts server span of the request. Trace of the caller is continued
+// if request has W3C traceparent header and comes from trusted proxy, other clients cannot
+// force sampling or join their requests to foreign traces.
+func TracingMiddleware(
+	_ context.Context,
+	provider trace.TracerProvider,
+	trusted config.CIDRList,
+) func(http.Handler) http.Handler {
+	tr := provider.Tracer(tracerName)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if peer, ok := remoteAddr(r); ok && trusted.Contains(peer) {
+				ctx = propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(r.Header))
+			}
+			attrs := []attribute.KeyValue{
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ServerAddress(r.Host),
+				attribute.String("lb.request_id", requestid.FromContext(ctx)),
+			}
+			if addr, ok := ClientIP(r, trusted); ok {
+				attrs = append(attrs, semconv.ClientAddress(addr.String()))
+			}
+			// Router renames span when the route is known
+			ctx, span := tr.Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(attrs...),
+			)
+			defer span.End()
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			span.SetAttributes(semconv.HTTPResponseStatusCode(sw.status))
+			if sw.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(sw.status))
+			}
+		})
+	}
+}
+
+// statusWriter remembers status of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach flushing of the original writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
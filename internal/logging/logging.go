@@ -0,0 +1,180 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/requestid"
+)
+
+// Keys of attributes shared by all components
+const (
+	ComponentKey = "component"
+	RequestIDKey = "request_id"
+	ClientIDKey  = "client_id"
+	BackendKey   = "backend"
+	ErrorKey     = "error"
+)
+
+const (
+	defaultSamplingInitial    = 100
+	defaultSamplingThereafter = 100
+	defaultSamplingInterval   = time.Second
+)
+
+// New creates logger writing to w by the config. Records logged with context get ID of the request.
+// Records are sampled, so hot paths cannot flood the log.
+func New(cfg config.LogConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+		}
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	sampling := config.LogSamplingConfig{}
+	if cfg.Sampling != nil {
+		sampling = *cfg.Sampling
+	}
+	if sampling.Initial <= 0 {
+		sampling.Initial = defaultSamplingInitial
+	}
+	if sampling.Thereafter <= 0 {
+		sampling.Thereafter = defaultSamplingThereafter
+	}
+	interval := sampling.IntervalMS.AsDuration()
+	if interval <= 0 {
+		interval = defaultSamplingInterval
+	}
+	if sampling.Thereafter > 1 {
+		handler = newSamplingHandler(handler, sampling.Initial, sampling.Thereafter, interval)
+	}
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+// Component returns logger of the component, nil logger is replaced with the default one
+func Component(logger *slog.Logger, name string) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With(ComponentKey, name)
+}
+
+type contextKey struct{}
+
+// NewContext returns context carrying the logger, handlers of the request take it with FromContext
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns logger of the context, slog.Default() if there is none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Discard returns logger which writes nothing, for tests
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+// contextHandler adds ID of the request to records logged with its context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestid.FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String(RequestIDKey, id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// samplingHandler writes first records with the same level and message every interval,
+// then every thereafter-th of them. Warnings and errors are never dropped.
+type samplingHandler struct {
+	slog.Handler
+	sampler *sampler
+}
+
+type sampler struct {
+	initial    uint64
+	thereafter uint64
+	interval   time.Duration
+
+	mu       sync.Mutex
+	resetAt  time.Time
+	counters map[samplingKey]uint64
+}
+
+type samplingKey struct {
+	level   slog.Level
+	message string
+}
+
+func newSamplingHandler(handler slog.Handler, initial, thereafter int, interval time.Duration) *samplingHandler {
+	return &samplingHandler{
+		Handler: handler,
+		sampler: &sampler{
+			initial:    uint64(initial),
+			thereafter: uint64(thereafter),
+			interval:   interval,
+			counters:   make(map[samplingKey]uint64),
+		},
+	}
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn && !h.sampler.keep(r.Time, samplingKey{level: r.Level, message: r.Message}) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), sampler: h.sampler}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), sampler: h.sampler}
+}
+
+func (s *sampler) keep(now time.Time, key samplingKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Messages are constant strings, so counters of all of them are dropped at once
+	if now.After(s.resetAt) {
+		clear(s.counters)
+		s.resetAt = now.Add(s.interval)
+	}
+	s.counters[key]++
+	n := s.counters[key]
+	return n <= s.initial || (n-s.initial)%s.thereafter == 0
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/requestid"
+)
+
+func TestNew_InvalidConfig(t *testing.T) {
+	t.Parallel()
+	_, err := New(config.LogConfig{Level: "verbose"}, &bytes.Buffer{})
+	require.Error(t, err)
+	_, err = New(config.LogConfig{Format: "xml"}, &bytes.Buffer{})
+	require.Error(t, err)
+}
+
+func TestNew_JSONWithRequestID(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger, err := New(config.LogConfig{Level: "warn", Format: "json"}, &buf)
+	require.NoError(t, err)
+
+	ctx := requestid.NewContext(context.Background(), "abc")
+	logger = Component(logger, "gateway")
+	logger.InfoContext(ctx, "Below level")
+	logger.WarnContext(ctx, "Request is rejected", ClientIDKey, "client")
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	require.Equal(t, "WARN", record["level"])
+	require.Equal(t, "Request is rejected", record["msg"])
+	require.Equal(t, "gateway", record[ComponentKey])
+	require.Equal(t, "client", record[ClientIDKey])
+	require.Equal(t, "abc", record[RequestIDKey])
+}
+
+func TestNew_Sampling(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger, err := New(config.LogConfig{Sampling: &config.LogSamplingConfig{
+		Initial:    2,
+		Thereafter: 3,
+		IntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	}}, &buf)
+	require.NoError(t, err)
+
+	for range 8 {
+		logger.Info("Repeated")
+		logger.Warn("Store is unavailable")
+		logger.Error("Failed")
+	}
+	logger.Info("Other")
+	// 1st, 2nd, then 5th and 8th records are written
+	require.Equal(t, 4, strings.Count(buf.String(), "msg=Repeated"))
+	require.Equal(t, 1, strings.Count(buf.String(), "msg=Other"))
+	require.Equal(t, 8, strings.Count(buf.String(), `msg="Store is unavailable"`), "warnings are not sampled")
+	require.Equal(t, 8, strings.Count(buf.String(), "msg=Failed"), "errors are not sampled")
+}
+
+func TestFromContext(t *testing.T) {
+	t.Parallel()
+	require.Same(t, slog.Default(), FromContext(context.Background()))
+	logger := Discard()
+	require.Same(t, logger, FromContext(NewContext(context.Background(), logger)))
+}
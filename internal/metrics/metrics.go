@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+const namespace = "lb"
+
+// Rate limit results of the request checked by the policy
+const (
+	ResultAllowed        = "allowed"
+	ResultRejected       = "rejected"
+	ResultDryRunRejected = "dry_run_rejected"
+)
+
+// Metrics are Prometheus metrics of the balancer. Methods of nil Metrics record nothing,
+// so components work the same way with metrics disabled.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests           *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	rateLimitDecisions *prometheus.CounterVec
+	healthChecks       *prometheus.HistogramVec
+	healthCheckErrors  *prometheus.CounterVec
+
+	// Pools and limiters whose state is collected on scrape
+	mu        sync.Mutex
+	upstreams map[string]*balancer.LoadBalancer
+	limiters  map[string][]*ratelimit.RateLimiter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Requests by route, backend and response status. Route and backend are empty if request was not proxied.",
+		}, []string{"route", "backend", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time from the request to the end of the response by route, backend and response status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "backend", "status"}),
+		rateLimitDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ratelimit_requests_total",
+			Help:      "Requests checked by the rate limit policy by result: allowed, rejected or dry_run_rejected.",
+		}, []string{"policy", "result"}),
+		healthChecks: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_health_check_duration_seconds",
+			Help:      "Duration of backend health checks.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"upstream", "backend"}),
+		healthCheckErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_health_check_failures_total",
+			Help:      "Health checks which found the backend dead.",
+		}, []string{"upstream", "backend"}),
+		upstreams: make(map[string]*balancer.LoadBalancer),
+		limiters:  make(map[string][]*ratelimit.RateLimiter),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.rateLimitDecisions,
+		m.healthChecks,
+		m.healthCheckErrors,
+		&stateCollector{metrics: m},
+	)
+	return m
+}
+
+// Handler serves metrics in Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records finished request
+func (m *Metrics) ObserveRequest(route, backend string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(route, backend, code).Inc()
+	m.requestDuration.WithLabelValues(route, backend, code).Observe(duration.Seconds())
+}
+
+// ObserveRateLimit records result of the policy check
+func (m *Metrics) ObserveRateLimit(policy, result string) {
+	if m == nil {
+		return
+	}
+	m.rateLimitDecisions.WithLabelValues(policy, result).Inc()
+}
+
+// HealthCheckObserver returns balancer option recording health checks of the upstream pool
+func (m *Metrics) HealthCheckObserver(upstream string) func(*balancer.LoadBalancer) {
+	if m == nil {
+		return func(*balancer.LoadBalancer) {}
+	}
+	return balancer.WithHealthCheckObserver(func(backend *url.URL, duration time.Duration, alive bool) {
+		m.healthChecks.WithLabelValues(upstream, backend.String()).Observe(duration.Seconds())
+		if !alive {
+			m.healthCheckErrors.WithLabelValues(upstream, backend.String()).Inc()
+		}
+	})
+}
+
+// WatchUpstream exposes health and active connections of the pool backends
+func (m *Metrics) WatchUpstream(name string, lb *balancer.LoadBalancer) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.upstreams[name] = lb
+}
+
+// WatchLimiter exposes number of clients tracked by the limiter of the policy
+func (m *Metrics) WatchLimiter(policy string, rl *ratelimit.RateLimiter) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limiters[policy] = append(m.limiters[policy], rl)
+}
+
+var (
+	backendUpDesc = prometheus.NewDesc(
+		namespace+"_backend_up",
+		"Whether the backend is alive by the last health check.",
+		[]string{"upstream", "backend"}, nil,
+	)
+	backendConnsDesc = prometheus.NewDesc(
+		namespace+"_backend_active_connections",
+		"Requests currently proxied to the backend.",
+		[]string{"upstream", "backend"}, nil,
+	)
+	limiterClientsDesc = prometheus.NewDesc(
+		namespace+"_ratelimit_clients",
+		"Client buckets tracked by the rate limiter of the policy.",
+		[]string{"policy"}, nil,
+	)
+)
+
+// stateCollector reads state of the backends and limiters on scrape, so it is never stale
+type stateCollector struct {
+	metrics *Metrics
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- backendUpDesc
+	ch <- backendConnsDesc
+	ch <- limiterClientsDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+	for name, lb := range c.metrics.upstreams {
+		for _, b := range lb.Backends() {
+			up := 0.0
+			if b.IsAlive() {
+				up = 1
+			}
+			ch <- prometheus.MustNewConstMetric(backendUpDesc, prometheus.GaugeValue, up, name, b.URL.String())
+			ch <- prometheus.MustNewConstMetric(
+				backendConnsDesc, prometheus.GaugeValue, float64(b.ActiveConns()), name, b.URL.String(),
+			)
+		}
+	}
+	for policy, limiters := range c.metrics.limiters {
+		clients := 0
+		for _, rl := range limiters {
+			clients += rl.Len()
+		}
+		ch <- prometheus.MustNewConstMetric(limiterClientsDesc, prometheus.GaugeValue, float64(clients), policy)
+	}
+}
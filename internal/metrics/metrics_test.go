@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	t.Parallel()
+	var m *Metrics
+	m.ObserveRequest("api", "http://backend", http.StatusOK, time.Millisecond)
+	m.ObserveRateLimit("global", ResultAllowed)
+	m.WatchUpstream("default", nil)
+	m.WatchLimiter("global", nil)
+	lb := balancer.New(
+		t.Context(),
+		nil,
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+		m.HealthCheckObserver("default"),
+	)
+	require.Empty(t, lb.Backends())
+}
+
+func TestMetrics_HealthChecks(t *testing.T) {
+	t.Parallel()
+	dead, err := url.Parse("http://127.0.0.1:1")
+	require.NoError(t, err)
+	m := New()
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: dead}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 20},
+		m.HealthCheckObserver("api"),
+	)
+	m.WatchUpstream("api", lb)
+
+	failures := `lb_backend_health_check_failures_total{backend="http://127.0.0.1:1",upstream="api"}`
+	require.Eventually(t, func() bool { return strings.Contains(scrape(t, m), failures) }, time.Second, 20*time.Millisecond)
+	require.Contains(t, scrape(t, m), `lb_backend_up{backend="http://127.0.0.1:1",upstream="api"} 0`)
+}
+
+func TestMetrics_LimiterClients(t *testing.T) {
+	t.Parallel()
+	newLimiter := func() *ratelimit.RateLimiter {
+		rl, err := ratelimit.New(t.Context(), "token_bucket", config.TokenBucketLimiterOptions{
+			DefaultCapacity:         1,
+			DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+		})
+		require.NoError(t, err)
+		return rl
+	}
+	first, second := newLimiter(), newLimiter()
+	m := New()
+	// Policies with the same name are counted together
+	m.WatchLimiter("search", first)
+	m.WatchLimiter("search", second)
+
+	for _, key := range []string{"a", "b"} {
+		_, err := first.AllowRequest(context.Background(), key)
+		require.NoError(t, err)
+	}
+	_, err := second.AllowRequest(context.Background(), "c")
+	require.NoError(t, err)
+	require.Contains(t, scrape(t, m), `lb_ratelimit_clients{policy="search"} 3`)
+}
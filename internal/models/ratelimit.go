@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// RateLimitDecision is the result of rate limit check for a single request
+type RateLimitDecision struct {
+	Allowed bool
+	// Limit is maximum number of requests client can make at once
+	Limit int
+	// Remaining is number of requests client can make right now after this one
+	Remaining int
+	// ResetAfter is time until the limit is fully restored
+	ResetAfter time.Duration
+	// RetryAfter is time until the next request can be allowed, set only for rejected request
+	RetryAfter time.Duration
+	// Delay is time the allowed request must wait before it is forwarded (shaping algorithms)
+	Delay time.Duration
+}
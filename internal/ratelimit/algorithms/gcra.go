@@ -0,0 +1,123 @@
+package ratelimit_algorithms
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/models"
+)
+
+// GCRALimiter implements Generic Cell Rate Algorithm.
+// The only state is theoretical arrival time (TAT) of the next request,
+// so it is O(1) memory and knows exactly when the next request can be allowed.
+// In shaping mode it works as leaky bucket: requests are delayed up to maxDelay instead of rejecting.
+type GCRALimiter struct {
+	// params are read on every request, so limits can be changed for live bucket
+	params   func() gcraParams
+	shaping  bool
+	maxDelay time.Duration
+	now      func() time.Time
+
+	tat atomic.Int64 // unix nanoseconds
+}
+
+type gcraParams struct {
+	// interval between requests at steady rate
+	interval time.Duration
+	// tolerance is how far TAT can be ahead of now, it gives burst of requests
+	tolerance time.Duration
+	// unlimited allows every request
+	unlimited bool
+}
+
+func newGCRAParams(rate int, period time.Duration, burst int) gcraParams {
+	interval := max(period/time.Duration(max(rate, 1)), time.Nanosecond)
+	return gcraParams{
+		interval:  interval,
+		tolerance: interval * time.Duration(max(burst, 1)-1),
+	}
+}
+
+// cost is number of slots taken by request of n units. Request costlier than the burst (plus slots
+// which can be delayed up to maxDelay) takes all of it, otherwise it would never be allowed.
+func (p gcraParams) cost(n int, maxDelay time.Duration) int {
+	return min(max(n, 1), int((p.tolerance+maxDelay)/p.interval)+1)
+}
+
+func NewGCRALimiter(options config.GCRALimiterOptions) *GCRALimiter {
+	params := newGCRAParams(options.Rate, options.PeriodMS.AsDuration(), options.Burst)
+	return &GCRALimiter{
+		params:   func() gcraParams { return params },
+		shaping:  options.Shaping,
+		maxDelay: options.MaxDelayMS.AsDuration(),
+		now:      time.Now,
+	}
+}
+
+// reserve tries to take n slots at once, the last of them must start not later than maxDelay from now
+func (g *GCRALimiter) reserve(n int, maxDelay time.Duration) models.RateLimitDecision {
+	p := g.params()
+	if p.unlimited {
+		return models.RateLimitDecision{Allowed: true}
+	}
+	increment := int64(p.interval) * int64(p.cost(n, maxDelay))
+	for {
+		now := g.now().UnixNano()
+		tat := g.tat.Load()
+		// Bucket is drained since last request: start from now
+		start := max(tat, now)
+		newTat := start + increment
+		delay := time.Duration(newTat - int64(p.interval) - now - int64(p.tolerance))
+
+		if delay > maxDelay {
+			return g.decision(p, now, tat, false, delay)
+		}
+		if g.tat.CompareAndSwap(tat, newTat) {
+			return g.decision(p, now, newTat, true, delay)
+		}
+	}
+}
+
+func (g *GCRALimiter) decision(p gcraParams, now, tat int64, allowed bool, delay time.Duration) models.RateLimitDecision {
+	ahead := time.Duration(max(tat-now, 0))
+	decision := models.RateLimitDecision{
+		Allowed:    allowed,
+		Limit:      int(p.tolerance/p.interval) + 1,
+		Remaining:  max(0, int((p.tolerance+p.interval-ahead)/p.interval)),
+		ResetAfter: ahead,
+	}
+	if allowed {
+		decision.Delay = max(delay, 0)
+	} else {
+		decision.RetryAfter = delay
+	}
+	return decision
+}
+
+// DecideN takes n slots. Without shaping it never delays: request is either allowed now
+// or rejected with time to wait until the next allowed one. With shaping request can be delayed up to maxDelay.
+func (g *GCRALimiter) DecideN(n int) models.RateLimitDecision {
+	return g.reserve(n, g.delayLimit())
+}
+
+func (g *GCRALimiter) delayLimit() time.Duration {
+	if !g.shaping {
+		return 0
+	}
+	return g.maxDelay
+}
+
+// RefundN gives back n slots taken by the allowed decision, TAT moves back as if they were never reserved
+func (g *GCRALimiter) RefundN(n int) {
+	p := g.params()
+	if p.unlimited {
+		return
+	}
+	g.tat.Add(-int64(p.interval) * int64(p.cost(n, g.delayLimit())))
+}
+
+// Idle reports whether TAT is in the past, i.e. full burst is available again
+func (g *GCRALimiter) Idle() bool {
+	return g.tat.Load() <= g.now().UnixNano()
+}
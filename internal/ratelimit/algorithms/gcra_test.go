@@ -0,0 +1,163 @@
+package ratelimit_algorithms
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func BenchmarkGCRA(b *testing.B) {
+	log.SetOutput(io.Discard)
+
+	g := NewGCRALimiter(config.GCRALimiterOptions{
+		Rate:     100,
+		PeriodMS: config.DurationMs(10),
+		Burst:    100,
+	})
+
+	for b.Loop() {
+		allow(g)
+	}
+}
+
+func TestGCRALimiter_BurstAndRetryAfter(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	g := NewGCRALimiter(config.GCRALimiterOptions{
+		Rate:     10,
+		PeriodMS: config.DurationMs(1000),
+		Burst:    3,
+	})
+	g.now = clock.Now
+
+	for i := range 3 {
+		require.True(t, allow(g), "request %d of burst should be allowed", i+1)
+	}
+	decision := g.DecideN(1)
+	require.False(t, decision.Allowed, "request over the burst should be denied")
+	require.Equal(t, 100*time.Millisecond, decision.RetryAfter, "next slot is one emission interval later")
+	require.Equal(t, 3, decision.Limit)
+	require.Equal(t, 0, decision.Remaining)
+	require.Equal(t, 300*time.Millisecond, decision.ResetAfter)
+
+	clock.Advance(decision.RetryAfter)
+	require.True(t, allow(g), "request should be allowed exactly after retry delay")
+	require.False(t, allow(g))
+
+	require.False(t, g.Idle())
+	clock.Advance(time.Second)
+	require.True(t, g.Idle())
+}
+
+func TestGCRALimiter_Shaping(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	g := NewGCRALimiter(config.GCRALimiterOptions{
+		Rate:       10,
+		PeriodMS:   config.DurationMs(1000),
+		Burst:      1,
+		Shaping:    true,
+		MaxDelayMS: config.DurationMs(250),
+	})
+	g.now = clock.Now
+
+	expected := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond}
+	for i, want := range expected {
+		decision := g.DecideN(1)
+		require.True(t, decision.Allowed, "request %d should be delayed, not rejected", i+1)
+		require.Equal(t, want, decision.Delay)
+	}
+
+	decision := g.DecideN(1)
+	require.False(t, decision.Allowed, "delay over the maximum should be rejected")
+	require.Equal(t, 300*time.Millisecond, decision.RetryAfter)
+}
+
+func TestGCRALimiter_Refund(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	g := NewGCRALimiter(config.GCRALimiterOptions{
+		Rate:       10,
+		PeriodMS:   config.DurationMs(1000),
+		Burst:      1,
+		Shaping:    true,
+		MaxDelayMS: config.DurationMs(250),
+	})
+	g.now = clock.Now
+
+	require.True(t, g.DecideN(1).Allowed)
+	decision := g.DecideN(2)
+	require.True(t, decision.Allowed)
+	require.Equal(t, 200*time.Millisecond, decision.Delay)
+
+	g.RefundN(2)
+	decision = g.DecideN(1)
+	require.True(t, decision.Allowed)
+	require.Equal(t, 100*time.Millisecond, decision.Delay, "refunded slots are free again")
+}
+
+func TestGCRALimiter_Concurrent(t *testing.T) {
+	t.Parallel()
+	g := NewGCRALimiter(config.GCRALimiterOptions{
+		Rate:     1,
+		PeriodMS: config.DurationMs(time.Hour.Milliseconds()),
+		Burst:    5,
+	})
+
+	results := make(chan bool, 20)
+	for range 20 {
+		go func() {
+			results <- allow(g)
+		}()
+	}
+
+	allowed := 0
+	for range 20 {
+		if <-results {
+			allowed++
+		}
+	}
+
+	require.Equal(t, 5, allowed, "exactly burst requests should pass concurrently")
+}
+
+func TestGCRALimiter_AllowN(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	g := NewGCRALimiter(config.GCRALimiterOptions{
+		Rate:     10,
+		PeriodMS: config.DurationMs(1000),
+		Burst:    5,
+	})
+	g.now = clock.Now
+
+	require.True(t, allowN(g, 3))
+	decision := g.DecideN(3)
+	require.False(t, decision.Allowed, "only 2 slots of burst left")
+	require.Equal(t, 100*time.Millisecond, decision.RetryAfter)
+	require.Equal(t, 2, decision.Remaining)
+
+	clock.Advance(decision.RetryAfter)
+	require.True(t, allowN(g, 3))
+	require.False(t, allow(g))
+}
+
+func TestGCRALimiter_CostOverBurst(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	g := NewGCRALimiter(config.GCRALimiterOptions{
+		Rate:     10,
+		PeriodMS: config.DurationMs(1000),
+		Burst:    3,
+	})
+	g.now = clock.Now
+
+	require.True(t, allowN(g, 100), "request costlier than the burst takes all of it")
+	decision := g.DecideN(100)
+	require.False(t, decision.Allowed)
+	require.Equal(t, 300*time.Millisecond, decision.RetryAfter)
+}
@@ -0,0 +1,22 @@
+package ratelimit_algorithms
+
+import (
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+// NewPlanLimiter creates GCRA bucket whose rate and burst are taken from the client plan on every request.
+// TAT does not depend on limits, so when plan is changed the bucket just continues with the new ones.
+func NewPlanLimiter(limits func() config.PlanLimits) *GCRALimiter {
+	return &GCRALimiter{
+		params: func() gcraParams {
+			l := limits()
+			if l.Rate <= 0 {
+				return gcraParams{unlimited: true}
+			}
+			return newGCRAParams(l.Rate, l.PeriodMS.AsDuration(), l.Burst)
+		},
+		now: time.Now,
+	}
+}
@@ -0,0 +1,322 @@
+package ratelimit_algorithms
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/models"
+	"github.com/zahartd/load_balancer/internal/resp"
+)
+
+const (
+	defaultRedisTimeout      = 50 * time.Millisecond
+	defaultRedisSyncInterval = 100 * time.Millisecond
+)
+
+// redisTokenBucketScript takes cost tokens from the bucket with lazy refill.
+// Bucket is hash with tokens and time of the last refill (microseconds of the store clock,
+// so replicas clocks skew does not matter). With force flag tokens are taken even if
+// there are not enough of them, it is used to flush requests already allowed locally.
+// Returns {allowed, tokens left, microseconds until the next refill}.
+// Requires Redis 5+ (TIME call before writes relies on effects replication).
+var redisTokenBucketScript = resp.NewScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local force = ARGV[4] == "1"
+local ttl = tonumber(ARGV[5])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+
+local refill = math.floor((now - ts) / interval)
+if refill > 0 then
+	tokens = math.min(capacity, tokens + refill)
+	ts = ts + refill * interval
+end
+if tokens >= capacity then
+	ts = now
+end
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+elseif force then
+	tokens = 0
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "ts", ts)
+redis.call("PEXPIRE", KEYS[1], ttl)
+return {allowed, tokens, interval - (now - ts)}
+`)
+
+// RedisTokenBucketLimiter is token bucket which state is kept in Redis-compatible store,
+// so all balancer replicas share the same limit of the client.
+type RedisTokenBucketLimiter struct {
+	ctx      context.Context
+	client   *resp.Client
+	key      string
+	capacity int
+	interval time.Duration
+	timeout  time.Duration
+	failOpen bool
+	logger   *slog.Logger
+
+	// Local cache mode: requests are allowed from the last known number of tokens
+	// and pending ones are flushed to the store periodically
+	localCache   bool
+	syncInterval time.Duration
+	mu           sync.Mutex
+	tokens       int
+	pending      int
+	// flushing is number of pending requests taken by syncs in progress
+	flushing int
+	// syncedAt is time of the last sync, local state older than syncInterval is refreshed before the decision
+	syncedAt time.Time
+	// refreshing is set while one request refreshes stale state, others decide from the local one
+	refreshing bool
+}
+
+// WithRedisLogger sets logger of the store errors, by default it is slog.Default()
+func WithRedisLogger(logger *slog.Logger) func(*RedisTokenBucketLimiter) {
+	return func(rtb *RedisTokenBucketLimiter) {
+		rtb.logger = logger
+	}
+}
+
+func NewRedisTokenBucketLimiter(
+	ctx context.Context,
+	client *resp.Client,
+	key string,
+	options config.RedisTokenBucketLimiterOptions,
+	limiterOptions ...func(*RedisTokenBucketLimiter),
+) *RedisTokenBucketLimiter {
+	rtb := &RedisTokenBucketLimiter{
+		ctx:        ctx,
+		client:     client,
+		key:        options.KeyPrefix + key,
+		capacity:   options.Capacity,
+		interval:   max(options.RefillIntervalMS.AsDuration(), time.Millisecond),
+		timeout:    options.TimeoutMS.AsDuration(),
+		failOpen:   options.FailOpen,
+		localCache: options.Mode == "local_cache",
+	}
+	if rtb.timeout <= 0 {
+		rtb.timeout = defaultRedisTimeout
+	}
+	for _, o := range limiterOptions {
+		o(rtb)
+	}
+	if rtb.logger == nil {
+		rtb.logger = logging.Component(nil, "ratelimit")
+	}
+
+	if rtb.localCache {
+		// Bucket is created under registry lock, so do not wait for the store here:
+		// start optimistically with full bucket, the first sync reconciles it with the store
+		rtb.tokens = rtb.capacity
+		rtb.syncedAt = time.Now()
+		rtb.syncInterval = options.SyncIntervalMS.AsDuration()
+		if rtb.syncInterval <= 0 {
+			rtb.syncInterval = defaultRedisSyncInterval
+		}
+		go rtb.syncRoutine(ctx)
+	}
+
+	return rtb
+}
+
+// redisBucketState is reply of redisTokenBucketScript
+type redisBucketState struct {
+	allowed    bool
+	tokens     int
+	nextRefill time.Duration
+}
+
+func (rtb *RedisTokenBucketLimiter) take(ctx context.Context, cost int, force bool) (redisBucketState, error) {
+	ctx, cancel := context.WithTimeout(ctx, rtb.timeout)
+	defer cancel()
+
+	forceFlag := "0"
+	if force {
+		forceFlag = "1"
+	}
+	ttl := time.Duration(rtb.capacity)*rtb.interval + time.Second
+	reply, err := redisTokenBucketScript.Run(ctx, rtb.client, []string{rtb.key},
+		strconv.Itoa(rtb.capacity),
+		strconv.FormatInt(rtb.interval.Microseconds(), 10),
+		strconv.Itoa(cost),
+		forceFlag,
+		strconv.FormatInt(ttl.Milliseconds(), 10),
+	)
+	if err != nil {
+		return redisBucketState{}, err
+	}
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 3 {
+		return redisBucketState{}, fmt.Errorf("unexpected token bucket script reply: %v", reply)
+	}
+	allowed, ok1 := values[0].(int64)
+	tokens, ok2 := values[1].(int64)
+	nextRefill, ok3 := values[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return redisBucketState{}, fmt.Errorf("unexpected token bucket script reply: %v", reply)
+	}
+	return redisBucketState{
+		allowed:    allowed == 1,
+		tokens:     int(tokens),
+		nextRefill: time.Duration(nextRefill) * time.Microsecond,
+	}, nil
+}
+
+func (rtb *RedisTokenBucketLimiter) DecideN(n int) models.RateLimitDecision {
+	// Request costlier than the whole bucket takes all of it, otherwise it would never be allowed
+	n = min(n, max(rtb.capacity, 1))
+	if rtb.localCache {
+		return rtb.decideLocal(n)
+	}
+
+	state, err := rtb.take(rtb.ctx, n, false)
+	if err != nil {
+		rtb.logger.Warn("Rate limit store is unavailable", "store_key", rtb.key, logging.ErrorKey, err)
+		return rtb.failDecision()
+	}
+	return rtb.decision(state, n)
+}
+
+func (rtb *RedisTokenBucketLimiter) decision(state redisBucketState, n int) models.RateLimitDecision {
+	decision := models.RateLimitDecision{
+		Allowed:   state.allowed,
+		Limit:     rtb.capacity,
+		Remaining: state.tokens,
+	}
+	if state.tokens < rtb.capacity {
+		decision.ResetAfter = state.nextRefill + time.Duration(rtb.capacity-state.tokens-1)*rtb.interval
+	}
+	if !state.allowed {
+		decision.RetryAfter = state.nextRefill + time.Duration(max(n-state.tokens-1, 0))*rtb.interval
+	}
+	return decision
+}
+
+// failDecision is used when the store is unreachable
+func (rtb *RedisTokenBucketLimiter) failDecision() models.RateLimitDecision {
+	if rtb.failOpen {
+		return models.RateLimitDecision{Allowed: true, Limit: rtb.capacity, Remaining: rtb.capacity}
+	}
+	return models.RateLimitDecision{Limit: rtb.capacity, RetryAfter: rtb.interval}
+}
+
+func (rtb *RedisTokenBucketLimiter) decideLocal(n int) models.RateLimitDecision {
+	rtb.mu.Lock()
+	refresh := !rtb.refreshing && time.Since(rtb.syncedAt) > rtb.syncInterval
+	if refresh {
+		rtb.refreshing = true
+	}
+	rtb.mu.Unlock()
+	if refresh {
+		// Periodic sync is skipped while there is nothing to flush, other replicas could take tokens since then.
+		// Only the first request after idle period waits for the store.
+		rtb.sync(rtb.ctx, true)
+		rtb.mu.Lock()
+		rtb.refreshing = false
+		rtb.mu.Unlock()
+	}
+
+	rtb.mu.Lock()
+	defer rtb.mu.Unlock()
+
+	allowed := rtb.tokens >= n
+	if allowed {
+		rtb.tokens -= n
+		rtb.pending += n
+	}
+	return rtb.decision(redisBucketState{allowed: allowed, tokens: rtb.tokens, nextRefill: rtb.interval}, n)
+}
+
+func (rtb *RedisTokenBucketLimiter) syncRoutine(ctx context.Context) {
+	rtb.sync(ctx, true)
+
+	ticker := time.NewTicker(rtb.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Do not lose requests allowed since the last sync
+			flushCtx, cancel := context.WithTimeout(context.Background(), rtb.timeout)
+			rtb.sync(flushCtx, false)
+			cancel()
+			return
+		case <-ticker.C:
+			rtb.sync(ctx, false)
+		}
+	}
+}
+
+// sync flushes locally allowed requests to the store and refreshes number of tokens.
+// Without pending requests store is called only if refresh is true.
+func (rtb *RedisTokenBucketLimiter) sync(ctx context.Context, refresh bool) {
+	rtb.mu.Lock()
+	pending := rtb.pending
+	rtb.pending = 0
+	rtb.flushing += pending
+	rtb.mu.Unlock()
+	if pending == 0 && !refresh {
+		return
+	}
+
+	state, err := rtb.take(ctx, pending, true)
+
+	rtb.mu.Lock()
+	defer rtb.mu.Unlock()
+	rtb.flushing -= pending
+	rtb.syncedAt = time.Now()
+	if err != nil {
+		// Requests are still allowed, they are flushed when the store is back
+		rtb.pending += pending
+		rtb.logger.Warn("Failed to sync rate limiter with store", "store_key", rtb.key, logging.ErrorKey, err)
+		if rtb.failOpen {
+			// Act as independent local limiter until the store is back
+			rtb.tokens = rtb.capacity
+		} else {
+			rtb.tokens = 0
+		}
+		return
+	}
+	// Requests allowed during the call are not in the store yet
+	rtb.tokens = max(state.tokens-rtb.pending, 0)
+}
+
+// RefundN gives back tokens allowed from the local state and not flushed to the store yet.
+// Without local cache tokens are taken in the store right away and cannot be given back.
+func (rtb *RedisTokenBucketLimiter) RefundN(n int) {
+	if !rtb.localCache {
+		return
+	}
+	rtb.mu.Lock()
+	defer rtb.mu.Unlock()
+	n = min(n, rtb.pending)
+	rtb.pending -= n
+	rtb.tokens = min(rtb.tokens+n, rtb.capacity)
+}
+
+// Idle reports whether all locally allowed requests are flushed to the store.
+// Bucket state itself lives in the store, so local limiter can be safely recreated.
+func (rtb *RedisTokenBucketLimiter) Idle() bool {
+	rtb.mu.Lock()
+	defer rtb.mu.Unlock()
+	return rtb.pending == 0 && rtb.flushing == 0
+}
@@ -0,0 +1,190 @@
+package ratelimit_algorithms
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/resp"
+)
+
+func newRedisReplica(
+	t *testing.T,
+	addr string,
+	options config.RedisTokenBucketLimiterOptions,
+	limiterOptions ...func(*RedisTokenBucketLimiter),
+) *RedisTokenBucketLimiter {
+	t.Helper()
+	client := resp.NewClient(addr, resp.WithTimeout(time.Second))
+	t.Cleanup(client.Close)
+	options.TimeoutMS = config.DurationMs(time.Second.Milliseconds())
+	return NewRedisTokenBucketLimiter(t.Context(), client, "client", options, limiterOptions...)
+}
+
+func TestRedisTokenBucketLimiter_SharedBetweenReplicas(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	options := config.RedisTokenBucketLimiterOptions{
+		KeyPrefix:        "rl:",
+		Capacity:         5,
+		RefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	}
+	first := newRedisReplica(t, store.Addr(), options)
+	second := newRedisReplica(t, store.Addr(), options)
+
+	allowed := 0
+	for range 4 {
+		if allow(first) {
+			allowed++
+		}
+		if allow(second) {
+			allowed++
+		}
+	}
+	require.Equal(t, 5, allowed, "replicas must share one bucket")
+
+	decision := first.DecideN(2)
+	require.False(t, decision.Allowed)
+	require.Equal(t, 5, decision.Limit)
+	require.Equal(t, 0, decision.Remaining)
+	require.Greater(t, decision.RetryAfter, 59*time.Minute)
+}
+
+func TestRedisTokenBucketLimiter_StoreUnavailable(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	options := config.RedisTokenBucketLimiterOptions{
+		Capacity:         5,
+		RefillIntervalMS: config.DurationMs(10),
+	}
+	options.FailOpen = true
+	failOpen := newRedisReplica(t, store.Addr(), options)
+	options.FailOpen = false
+	var logs bytes.Buffer
+	failClosed := newRedisReplica(t, store.Addr(), options, WithRedisLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	require.True(t, allow(failOpen))
+	require.True(t, allow(failClosed))
+
+	store.Close()
+
+	require.True(t, allow(failOpen), "requests should be allowed when store is down in fail-open mode")
+	require.False(t, allow(failClosed), "requests should be rejected when store is down in fail-closed mode")
+	require.Contains(t, logs.String(), `msg="Rate limit store is unavailable"`, "errors are written to the limiter logger")
+}
+
+func TestRedisTokenBucketLimiter_LocalCache(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	options := config.RedisTokenBucketLimiterOptions{
+		Capacity:         10,
+		RefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+		Mode:             "local_cache",
+		SyncIntervalMS:   config.DurationMs(20),
+	}
+	first := newRedisReplica(t, store.Addr(), options)
+	second := newRedisReplica(t, store.Addr(), options)
+
+	require.True(t, allowN(first, 6), "request is allowed from local state without store round trip")
+
+	require.Eventually(t, first.Idle, time.Second, 10*time.Millisecond)
+
+	// After sync the second replica knows about requests allowed by the first one
+	time.Sleep(30 * time.Millisecond)
+	require.False(t, allowN(second, 5))
+	require.True(t, allowN(second, 4))
+	require.Eventually(t, second.Idle, time.Second, 10*time.Millisecond)
+}
+
+func TestRedisTokenBucketLimiter_CostOverCapacity(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	rtb := newRedisReplica(t, store.Addr(), config.RedisTokenBucketLimiterOptions{
+		Capacity:         5,
+		RefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	})
+
+	require.True(t, allowN(rtb, 100), "request costlier than the bucket takes all of it")
+	decision := rtb.DecideN(100)
+	require.False(t, decision.Allowed)
+	require.Positive(t, decision.RetryAfter)
+}
+
+func TestRedisTokenBucketLimiter_ForceTake(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	rtb := newRedisReplica(t, store.Addr(), config.RedisTokenBucketLimiterOptions{
+		KeyPrefix:        "rl:",
+		Capacity:         3,
+		RefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	})
+
+	require.True(t, allowN(rtb, 2))
+	require.Equal(t, "1", store.HGet("rl:client", "tokens"))
+
+	// Force flag takes tokens even if there are not enough of them
+	state, err := rtb.take(t.Context(), 5, true)
+	require.NoError(t, err)
+	require.False(t, state.allowed)
+	require.Zero(t, state.tokens)
+	require.Equal(t, "0", store.HGet("rl:client", "tokens"))
+	require.Positive(t, store.TTL("rl:client"))
+}
+
+func TestRedisTokenBucketLimiter_LocalCacheSync(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	rtb := newRedisReplica(t, store.Addr(), config.RedisTokenBucketLimiterOptions{
+		Capacity:         10,
+		RefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+		Mode:             "local_cache",
+		SyncIntervalMS:   config.DurationMs(20),
+	})
+
+	require.True(t, allowN(rtb, 3))
+	require.Eventually(t, rtb.Idle, time.Second, 10*time.Millisecond)
+	require.Equal(t, "7", store.HGet("client", "tokens"))
+
+	calls := store.CommandCount()
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, calls, store.CommandCount(), "there is nothing to flush, store is not called")
+
+	// State is stale by now, so it is refreshed before the decision
+	store.HSet("client", "tokens", "1")
+	require.False(t, allowN(rtb, 2), "tokens taken by other replicas are known")
+	require.True(t, allow(rtb))
+
+	// Only one of concurrent requests refreshes stale state
+	require.Eventually(t, rtb.Idle, time.Second, 10*time.Millisecond)
+	store.HSet("client", "tokens", "10")
+	time.Sleep(50 * time.Millisecond)
+	calls = store.CommandCount()
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtb.DecideN(0)
+		}()
+	}
+	wg.Wait()
+	// miniredis counts commands called by the script too, so single script run is 5 commands
+	require.LessOrEqual(t, store.CommandCount()-calls, 2*5, "refresh and possibly periodic flush")
+	require.Eventually(t, rtb.Idle, time.Second, 10*time.Millisecond)
+	store.HSet("client", "tokens", "1")
+	time.Sleep(50 * time.Millisecond)
+	require.True(t, allow(rtb))
+
+	// Requests allowed while the store is down are flushed when it is back
+	store.Close()
+	time.Sleep(100 * time.Millisecond)
+	require.False(t, rtb.Idle(), "pending requests are kept after failed sync")
+	require.NoError(t, store.Restart())
+	require.Eventually(t, rtb.Idle, time.Second, 10*time.Millisecond)
+	require.Equal(t, "0", store.HGet("client", "tokens"))
+}
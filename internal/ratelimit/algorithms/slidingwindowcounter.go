@@ -0,0 +1,117 @@
+package ratelimit_algorithms
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/models"
+)
+
+// SlidingWindowCounterLimiter approximates rolling window with two fixed windows:
+// previous window count is weighted by its overlap with the rolling one.
+// It needs O(1) memory per client at the cost of small inaccuracy.
+type SlidingWindowCounterLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	current     int
+	previous    int
+}
+
+func NewSlidingWindowCounterLimiter(options config.SlidingWindowCounterLimiterOptions) *SlidingWindowCounterLimiter {
+	return &SlidingWindowCounterLimiter{
+		limit:  options.Limit,
+		window: options.WindowMS.AsDuration(),
+		now:    time.Now,
+	}
+}
+
+// advanceLocked moves fixed windows forward to the one containing now, swc.mu must be held
+func (swc *SlidingWindowCounterLimiter) advanceLocked(now time.Time) {
+	start := now.Truncate(swc.window)
+	switch {
+	case start.Equal(swc.windowStart):
+	case start.Sub(swc.windowStart) == swc.window:
+		swc.previous = swc.current
+		swc.current = 0
+	default:
+		// More than one window passed, nothing to take into account
+		swc.previous = 0
+		swc.current = 0
+	}
+	swc.windowStart = start
+}
+
+// DecideN counts n requests at once or none of them
+func (swc *SlidingWindowCounterLimiter) DecideN(n int) models.RateLimitDecision {
+	now := swc.now()
+
+	swc.mu.Lock()
+	defer swc.mu.Unlock()
+
+	// Request costlier than the whole limit takes all of it, otherwise it would never be allowed
+	n = min(n, max(swc.limit, 1))
+	swc.advanceLocked(now)
+	elapsed := now.Sub(swc.windowStart)
+	estimated := float64(swc.previous)*swc.weight(elapsed) + float64(swc.current)
+
+	decision := models.RateLimitDecision{Limit: swc.limit}
+	if estimated+float64(n) <= float64(swc.limit) {
+		swc.current += n
+		estimated += float64(n)
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = swc.retryAfterLocked(elapsed, n)
+	}
+	decision.Remaining = max(0, int(float64(swc.limit)-estimated))
+
+	// Limit is fully restored when both windows slide out
+	switch {
+	case swc.current > 0:
+		decision.ResetAfter = 2*swc.window - elapsed
+	case swc.previous > 0:
+		decision.ResetAfter = swc.window - elapsed
+	}
+	return decision
+}
+
+// weight is the part of the previous window which overlaps the rolling one
+func (swc *SlidingWindowCounterLimiter) weight(elapsed time.Duration) float64 {
+	return 1 - float64(elapsed)/float64(swc.window)
+}
+
+// retryAfterLocked finds when estimated count drops enough to allow n more requests, swc.mu must be held
+func (swc *SlidingWindowCounterLimiter) retryAfterLocked(elapsed time.Duration, n int) time.Duration {
+	free := float64(swc.limit - n)
+	if swc.current <= swc.limit-n && swc.previous > 0 {
+		// Wait in the current window until previous one decays
+		need := 1 - (free-float64(swc.current))/float64(swc.previous)
+		return time.Duration(need*float64(swc.window)) - elapsed
+	}
+	if n > swc.limit || swc.current == 0 {
+		return 2*swc.window - elapsed
+	}
+	// Current window is the previous one after the boundary
+	need := 1 - free/float64(swc.current)
+	return swc.window - elapsed + time.Duration(need*float64(swc.window))
+}
+
+// RefundN takes n requests back from the current window
+func (swc *SlidingWindowCounterLimiter) RefundN(n int) {
+	swc.mu.Lock()
+	defer swc.mu.Unlock()
+	swc.current = max(swc.current-min(n, max(swc.limit, 1)), 0)
+}
+
+// Idle reports whether both fixed windows are empty
+func (swc *SlidingWindowCounterLimiter) Idle() bool {
+	swc.mu.Lock()
+	defer swc.mu.Unlock()
+
+	swc.advanceLocked(swc.now())
+	return swc.previous == 0 && swc.current == 0
+}
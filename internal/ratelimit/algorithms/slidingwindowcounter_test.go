@@ -0,0 +1,101 @@
+package ratelimit_algorithms
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func BenchmarkSlidingWindowCounter(b *testing.B) {
+	log.SetOutput(io.Discard)
+
+	swc := NewSlidingWindowCounterLimiter(config.SlidingWindowCounterLimiterOptions{
+		Limit:    100,
+		WindowMS: config.DurationMs(10),
+	})
+
+	for b.Loop() {
+		allow(swc)
+	}
+}
+
+func TestSlidingWindowCounterLimiter_Exhaustion(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	swc := NewSlidingWindowCounterLimiter(config.SlidingWindowCounterLimiterOptions{
+		Limit:    3,
+		WindowMS: config.DurationMs(1000),
+	})
+	swc.now = clock.Now
+
+	for i := range 3 {
+		require.True(t, allow(swc), "request %d should be allowed", i+1)
+	}
+	require.False(t, allow(swc), "4th request in the window should be denied")
+}
+
+func TestSlidingWindowCounterLimiter_WeightsPreviousWindow(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	swc := NewSlidingWindowCounterLimiter(config.SlidingWindowCounterLimiterOptions{
+		Limit:    4,
+		WindowMS: config.DurationMs(1000),
+	})
+	swc.now = clock.Now
+
+	for range 4 {
+		require.True(t, allow(swc))
+	}
+
+	// Half of the previous window overlaps rolling one: 4*0.5 = 2 requests are still counted
+	clock.Advance(1500 * time.Millisecond)
+	require.True(t, allow(swc))
+	require.True(t, allow(swc))
+	decision := swc.DecideN(1)
+	require.False(t, decision.Allowed)
+	require.Equal(t, 0, decision.Remaining)
+	require.Equal(t, 1500*time.Millisecond, decision.ResetAfter)
+	require.Equal(t, 250*time.Millisecond, decision.RetryAfter, "previous window should decay to one request")
+
+	require.False(t, swc.Idle())
+	clock.Advance(2 * time.Second)
+	require.True(t, swc.Idle(), "both windows are empty")
+	require.True(t, allow(swc))
+}
+
+func TestSlidingWindowCounterLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	swc := NewSlidingWindowCounterLimiter(config.SlidingWindowCounterLimiterOptions{
+		Limit:    10,
+		WindowMS: config.DurationMs(1000),
+	})
+	swc.now = clock.Now
+
+	require.True(t, allowN(swc, 6))
+	require.False(t, allowN(swc, 5), "request must not be partially counted")
+	require.True(t, allowN(swc, 4))
+	require.False(t, allow(swc))
+
+	swc.RefundN(1)
+	require.True(t, allow(swc), "refunded request frees its slot")
+}
+
+func TestSlidingWindowCounterLimiter_CostOverLimit(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	swc := NewSlidingWindowCounterLimiter(config.SlidingWindowCounterLimiterOptions{
+		Limit:    5,
+		WindowMS: config.DurationMs(1000),
+	})
+	swc.now = clock.Now
+
+	require.True(t, allowN(swc, 100), "request costlier than the limit takes all of it")
+	decision := swc.DecideN(100)
+	require.False(t, decision.Allowed)
+	require.Positive(t, decision.RetryAfter)
+}
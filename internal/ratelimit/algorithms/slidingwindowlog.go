@@ -0,0 +1,89 @@
+package ratelimit_algorithms
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/models"
+)
+
+// SlidingWindowLogLimiter allows at most limit requests in any rolling window.
+// It keeps timestamps of accepted requests, so memory is O(limit) per client.
+type SlidingWindowLogLimiter struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu sync.Mutex
+	// log is ring buffer of accepted requests timestamps, oldest at head
+	log   []time.Time
+	head  int
+	count int
+}
+
+func NewSlidingWindowLogLimiter(options config.SlidingWindowLogLimiterOptions) *SlidingWindowLogLimiter {
+	return &SlidingWindowLogLimiter{
+		window: options.WindowMS.AsDuration(),
+		now:    time.Now,
+		log:    make([]time.Time, options.Limit),
+	}
+}
+
+// expireLocked drops timestamps which left the window, swl.mu must be held
+func (swl *SlidingWindowLogLimiter) expireLocked(now time.Time) {
+	for swl.count > 0 && !swl.log[swl.head].After(now.Add(-swl.window)) {
+		swl.head = (swl.head + 1) % len(swl.log)
+		swl.count--
+	}
+}
+
+// DecideN takes n slots of the window at once or none of them
+func (swl *SlidingWindowLogLimiter) DecideN(n int) models.RateLimitDecision {
+	now := swl.now()
+
+	swl.mu.Lock()
+	defer swl.mu.Unlock()
+
+	swl.expireLocked(now)
+	decision := models.RateLimitDecision{Limit: len(swl.log)}
+	if len(swl.log) == 0 {
+		// Zero limit rejects everything, but client must not retry right away
+		decision.RetryAfter = swl.window
+		return decision
+	}
+	// Request costlier than the whole window takes all of it, otherwise it would never be allowed
+	n = min(n, len(swl.log))
+	if swl.count+n <= len(swl.log) {
+		for range n {
+			swl.log[(swl.head+swl.count)%len(swl.log)] = now
+			swl.count++
+		}
+		decision.Allowed = true
+	} else {
+		// Enough slots are freed when the need-th oldest request leaves the window
+		need := swl.count + n - len(swl.log)
+		decision.RetryAfter = swl.log[(swl.head+need-1)%len(swl.log)].Add(swl.window).Sub(now)
+	}
+	decision.Remaining = len(swl.log) - swl.count
+	if swl.count > 0 {
+		newest := swl.log[(swl.head+swl.count-1)%len(swl.log)]
+		decision.ResetAfter = newest.Add(swl.window).Sub(now)
+	}
+	return decision
+}
+
+// RefundN drops n newest requests from the window
+func (swl *SlidingWindowLogLimiter) RefundN(n int) {
+	swl.mu.Lock()
+	defer swl.mu.Unlock()
+	swl.count = max(swl.count-min(n, len(swl.log)), 0)
+}
+
+// Idle reports whether there are no requests left in the window
+func (swl *SlidingWindowLogLimiter) Idle() bool {
+	swl.mu.Lock()
+	defer swl.mu.Unlock()
+
+	swl.expireLocked(swl.now())
+	return swl.count == 0
+}
@@ -0,0 +1,138 @@
+package ratelimit_algorithms
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/models"
+)
+
+// fakeClock is manually advanced time source for deterministic window tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// decider is any algorithm of the package
+type decider interface {
+	DecideN(n int) models.RateLimitDecision
+}
+
+func allow(d decider) bool {
+	return d.DecideN(1).Allowed
+}
+
+func allowN(d decider, n int) bool {
+	return d.DecideN(n).Allowed
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func BenchmarkSlidingWindowLog(b *testing.B) {
+	log.SetOutput(io.Discard)
+
+	swl := NewSlidingWindowLogLimiter(config.SlidingWindowLogLimiterOptions{
+		Limit:    100,
+		WindowMS: config.DurationMs(10),
+	})
+
+	for b.Loop() {
+		allow(swl)
+	}
+}
+
+func TestSlidingWindowLogLimiter_Exhaustion(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	swl := NewSlidingWindowLogLimiter(config.SlidingWindowLogLimiterOptions{
+		Limit:    3,
+		WindowMS: config.DurationMs(1000),
+	})
+	swl.now = clock.Now
+
+	for i := range 3 {
+		require.True(t, allow(swl), "request %d should be allowed", i+1)
+		clock.Advance(100 * time.Millisecond)
+	}
+	require.False(t, allow(swl), "4th request in the window should be denied")
+}
+
+func TestSlidingWindowLogLimiter_Rolling(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	swl := NewSlidingWindowLogLimiter(config.SlidingWindowLogLimiterOptions{
+		Limit:    2,
+		WindowMS: config.DurationMs(1000),
+	})
+	swl.now = clock.Now
+
+	require.True(t, allow(swl)) // t=0
+	clock.Advance(600 * time.Millisecond)
+	require.True(t, allow(swl)) // t=600ms
+	decision := swl.DecideN(1)
+	require.False(t, decision.Allowed)
+	require.Equal(t, 400*time.Millisecond, decision.RetryAfter, "slot is freed when the first request leaves the window")
+	require.Equal(t, time.Second, decision.ResetAfter, "limit is restored when the last request leaves the window")
+	require.Equal(t, 0, decision.Remaining)
+
+	// First request leaves the window, second one is still there
+	clock.Advance(400 * time.Millisecond)
+	require.True(t, allow(swl), "slot of the first request should be freed")
+	require.False(t, allow(swl), "there is no burst on window boundary")
+
+	require.False(t, swl.Idle())
+	clock.Advance(time.Second)
+	require.True(t, swl.Idle(), "all requests left the window")
+}
+
+func TestSlidingWindowLogLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	swl := NewSlidingWindowLogLimiter(config.SlidingWindowLogLimiterOptions{
+		Limit:    5,
+		WindowMS: config.DurationMs(1000),
+	})
+	swl.now = clock.Now
+
+	require.True(t, allowN(swl, 2)) // t=0
+	clock.Advance(500 * time.Millisecond)
+	require.True(t, allowN(swl, 3)) // t=500ms
+
+	decision := swl.DecideN(4)
+	require.False(t, decision.Allowed)
+	require.Equal(t, time.Second, decision.RetryAfter, "4 slots are freed when the request at 500ms leaves")
+
+	clock.Advance(500 * time.Millisecond)
+	require.True(t, allowN(swl, 2), "slots of the first request are freed")
+	require.False(t, allow(swl))
+}
+
+func TestSlidingWindowLogLimiter_CostOverLimit(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	swl := NewSlidingWindowLogLimiter(config.SlidingWindowLogLimiterOptions{
+		Limit:    5,
+		WindowMS: config.DurationMs(1000),
+	})
+	swl.now = clock.Now
+
+	require.True(t, allowN(swl, 100), "request costlier than the window takes all of it")
+	clock.Advance(200 * time.Millisecond)
+	decision := swl.DecideN(100)
+	require.False(t, decision.Allowed)
+	require.Equal(t, 800*time.Millisecond, decision.RetryAfter)
+
+	empty := NewSlidingWindowLogLimiter(config.SlidingWindowLogLimiterOptions{WindowMS: config.DurationMs(1000)})
+	decision = empty.DecideN(1)
+	require.False(t, decision.Allowed, "zero limit rejects everything")
+	require.Equal(t, time.Second, decision.RetryAfter)
+}
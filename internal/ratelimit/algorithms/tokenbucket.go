@@ -2,29 +2,32 @@ package ratelimit_algorithms
 
 import (
 	"context"
-	"log"
+	"sync"
 	"time"
 
 	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/models"
 )
 
 type TokenBucketLimiter struct {
-	tokens chan struct{}
+	capacity       int
+	refillInterval time.Duration
+
+	// Request can take several tokens at once, so check and take must be done under one lock
+	mu     sync.Mutex
+	tokens int
 }
 
 func NewTokenBucketLimiter(ctx context.Context, options config.TokenBucketLimiterOptions) *TokenBucketLimiter {
 	tbl := &TokenBucketLimiter{
-		tokens: make(chan struct{}, options.DefaultCapacity),
-	}
-
-	// Refill bucket to full capacity on start
-	for range options.DefaultCapacity {
-		tbl.tokens <- struct{}{}
+		capacity:       options.DefaultCapacity,
+		refillInterval: options.DefaultRefillIntervalMS.AsDuration(),
+		// Refill bucket to full capacity on start
+		tokens: options.DefaultCapacity,
 	}
 
 	// Start in separate goroutine periodical task with refill
-	refilInterval := options.DefaultRefillIntervalMS.AsDuration()
-	go tbl.refillRoutine(ctx, refilInterval)
+	go tbl.refillRoutine(ctx, tbl.refillInterval)
 
 	return tbl
 }
@@ -38,20 +41,48 @@ func (tbl *TokenBucketLimiter) refillRoutine(ctx context.Context, interval time.
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			select {
-			case tbl.tokens <- struct{}{}:
-			default:
-			}
+			tbl.mu.Lock()
+			tbl.tokens = min(tbl.tokens+1, tbl.capacity)
+			tbl.mu.Unlock()
 		}
 	}
 }
 
-func (tbl *TokenBucketLimiter) Allow() bool {
-	select {
-	case <-tbl.tokens:
-		log.Printf("In total, tokens are left: %d", len(tbl.tokens))
-		return true
-	default:
-		return false
+// DecideN takes n tokens at once or none of them. Refill ticks are not tracked,
+// so reset and retry times are upper bounds of real ones.
+func (tbl *TokenBucketLimiter) DecideN(n int) models.RateLimitDecision {
+	// Request costlier than the whole bucket takes all of it, otherwise it would never be allowed
+	n = min(n, max(tbl.capacity, 1))
+	tbl.mu.Lock()
+	allowed := tbl.tokens >= n
+	if allowed {
+		tbl.tokens -= n
+	}
+	remaining := tbl.tokens
+	tbl.mu.Unlock()
+
+	decision := models.RateLimitDecision{
+		Allowed:    allowed,
+		Limit:      tbl.capacity,
+		Remaining:  remaining,
+		ResetAfter: time.Duration(tbl.capacity-remaining) * tbl.refillInterval,
 	}
+	if !allowed {
+		decision.RetryAfter = time.Duration(n-remaining) * tbl.refillInterval
+	}
+	return decision
+}
+
+// RefundN puts n tokens back to the bucket
+func (tbl *TokenBucketLimiter) RefundN(n int) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	tbl.tokens = min(tbl.tokens+n, tbl.capacity)
+}
+
+// Idle reports whether bucket is full again, i.e. it is the same as a new one
+func (tbl *TokenBucketLimiter) Idle() bool {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	return tbl.tokens == tbl.capacity
 }
@@ -32,11 +32,11 @@ func BenchmarkTokenBucket(b *testing.B) {
 	tbl := NewTokenBucketLimiter(ctx, opts)
 
 	for range 50 {
-		tbl.Allow()
+		allow(tbl)
 	}
 
 	for b.Loop() {
-		tbl.Allow()
+		allow(tbl)
 	}
 }
 
@@ -51,10 +51,10 @@ func TestTokenBucketLimiter_Exhaustion(t *testing.T) {
 	tbl := NewTokenBucketLimiter(ctx, opts)
 
 	for i := range opts.DefaultCapacity {
-		require.True(t, tbl.Allow(), "token %d should be allowed", i+1)
+		require.True(t, allow(tbl), "token %d should be allowed", i+1)
 	}
 
-	require.False(t, tbl.Allow(), "4th token should be denied when capacity exhausted")
+	require.False(t, allow(tbl), "4th token should be denied when capacity exhausted")
 }
 
 func TestTokenBucketLimiter_Refill(t *testing.T) {
@@ -67,13 +67,13 @@ func TestTokenBucketLimiter_Refill(t *testing.T) {
 	}
 	tbl := NewTokenBucketLimiter(ctx, opts)
 
-	require.True(t, tbl.Allow(), "initial token should be allowed")
-	require.False(t, tbl.Allow(), "no tokens left immediately after consumption")
+	require.True(t, allow(tbl), "initial token should be allowed")
+	require.False(t, allow(tbl), "no tokens left immediately after consumption")
 
 	time.Sleep(150 * time.Millisecond)
 
-	require.True(t, tbl.Allow(), "token should be refilled after interval")
-	require.False(t, tbl.Allow(), "only one token refilled, next should be denied")
+	require.True(t, allow(tbl), "token should be refilled after interval")
+	require.False(t, allow(tbl), "only one token refilled, next should be denied")
 }
 
 func TestTokenBucketLimiter_NoOverflow(t *testing.T) {
@@ -86,15 +86,15 @@ func TestTokenBucketLimiter_NoOverflow(t *testing.T) {
 	}
 	tbl := NewTokenBucketLimiter(ctx, opts)
 
-	require.True(t, tbl.Allow())
-	require.True(t, tbl.Allow())
-	require.False(t, tbl.Allow())
+	require.True(t, allow(tbl))
+	require.True(t, allow(tbl))
+	require.False(t, allow(tbl))
 
 	time.Sleep(250 * time.Millisecond)
 
-	require.True(t, tbl.Allow(), "first refill token")
-	require.True(t, tbl.Allow(), "second refill token")
-	require.False(t, tbl.Allow(), "no overflow beyond capacity")
+	require.True(t, allow(tbl), "first refill token")
+	require.True(t, allow(tbl), "second refill token")
+	require.False(t, allow(tbl), "no overflow beyond capacity")
 }
 
 func TestTokenBucketLimiter_Concurrent(t *testing.T) {
@@ -110,7 +110,7 @@ func TestTokenBucketLimiter_Concurrent(t *testing.T) {
 	results := make(chan bool, 10)
 	for range 10 {
 		go func() {
-			results <- tbl.Allow()
+			results <- allow(tbl)
 		}()
 	}
 
@@ -123,3 +123,64 @@ func TestTokenBucketLimiter_Concurrent(t *testing.T) {
 
 	require.LessOrEqual(t, allowed, 5, "at most capacity requests should pass concurrently")
 }
+
+func TestTokenBucketLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+
+	opts := config.TokenBucketLimiterOptions{
+		DefaultCapacity:         10,
+		DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	}
+	tbl := NewTokenBucketLimiter(ctx, opts)
+
+	require.True(t, allowN(tbl, 7), "7 of 10 tokens should be taken")
+	require.False(t, allowN(tbl, 4), "4 tokens should be denied when only 3 left")
+	require.True(t, allowN(tbl, 3), "denied request must not take any tokens")
+	require.False(t, allow(tbl))
+
+	tbl.RefundN(2)
+	require.True(t, allowN(tbl, 2), "refunded tokens can be taken again")
+	tbl.RefundN(20)
+	require.Equal(t, 10, tbl.DecideN(0).Remaining, "refund does not overflow capacity")
+}
+
+func TestTokenBucketLimiter_AllowNConcurrent(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+
+	opts := config.TokenBucketLimiterOptions{
+		DefaultCapacity:         10,
+		DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	}
+	tbl := NewTokenBucketLimiter(ctx, opts)
+
+	results := make(chan bool, 10)
+	for range 10 {
+		go func() {
+			results <- allowN(tbl, 3)
+		}()
+	}
+
+	allowed := 0
+	for range 10 {
+		if <-results {
+			allowed++
+		}
+	}
+
+	require.Equal(t, 3, allowed, "only 3 requests of cost 3 fit into 10 tokens")
+}
+
+func TestTokenBucketLimiter_CostOverCapacity(t *testing.T) {
+	t.Parallel()
+	tbl := NewTokenBucketLimiter(t.Context(), config.TokenBucketLimiterOptions{
+		DefaultCapacity:         5,
+		DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	})
+
+	require.True(t, allowN(tbl, 100), "request costlier than the bucket takes all of it")
+	decision := tbl.DecideN(100)
+	require.False(t, decision.Allowed)
+	require.Equal(t, 5*time.Hour, decision.RetryAfter)
+}
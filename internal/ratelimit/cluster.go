@@ -0,0 +1,302 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/models"
+)
+
+const (
+	defaultPeerTimeout      = 100 * time.Millisecond
+	defaultPeerSyncInterval = 100 * time.Millisecond
+	// PeerHitsPath is endpoint of the peer listener which applies hits to the owned buckets
+	PeerHitsPath = "/ratelimit/hits"
+	// PeerSecretHeader carries the shared secret of the replicas
+	PeerSecretHeader = "X-Peer-Secret"
+)
+
+// Cluster makes limits of the balancer replicas global without external store.
+// Replicas are listed statically and every client key is owned by one of them
+// chosen by consistent hashing. Owner keeps the real bucket, the other replicas
+// either forward every request to it or decide locally from the last known state
+// and send batched counters to the owner periodically.
+type Cluster struct {
+	ctx          context.Context
+	self         string
+	secret       []byte
+	ring         *hashRing
+	batch        bool
+	syncInterval time.Duration
+	client       *http.Client
+	logger       *slog.Logger
+
+	mu       sync.RWMutex
+	limiters map[string]*RateLimiter
+
+	// dirty are not owned buckets with hits since the last flush
+	dirtyMu sync.Mutex
+	dirty   map[*peerLimiter]struct{}
+}
+
+// peerHit is hits of the client key sent to its owner
+type peerHit struct {
+	Limiter string `json:"limiter"`
+	Key     string `json:"key"`
+	Hits    int    `json:"hits"`
+	// Applied hits are already allowed by the sender, so they are taken even over the limit
+	Applied bool `json:"applied"`
+}
+
+// peerDecision is models.RateLimitDecision on the wire, durations are in nanoseconds
+type peerDecision struct {
+	Allowed    bool          `json:"allowed"`
+	Limit      int           `json:"limit"`
+	Remaining  int           `json:"remaining"`
+	ResetAfter time.Duration `json:"reset_after"`
+	RetryAfter time.Duration `json:"retry_after"`
+	Delay      time.Duration `json:"delay"`
+}
+
+// WithClusterLogger sets logger of the cluster, by default it is slog.Default()
+func WithClusterLogger(logger *slog.Logger) func(*Cluster) {
+	return func(c *Cluster) {
+		c.logger = logger
+	}
+}
+
+func NewCluster(ctx context.Context, cfg config.PeersConfig, options ...func(*Cluster)) (*Cluster, error) {
+	if !slices.Contains(cfg.Addresses, cfg.Self) {
+		return nil, fmt.Errorf("self address %q is not in the peers list", cfg.Self)
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("peers secret is required")
+	}
+	switch cfg.Mode {
+	case "", "forward", "batch":
+	default:
+		return nil, fmt.Errorf("unknown peers mode %q", cfg.Mode)
+	}
+
+	timeout := cfg.TimeoutMS.AsDuration()
+	if timeout <= 0 {
+		timeout = defaultPeerTimeout
+	}
+	c := &Cluster{
+		ctx:          ctx,
+		self:         cfg.Self,
+		secret:       []byte(cfg.Secret),
+		ring:         newHashRing(cfg.Addresses),
+		batch:        cfg.Mode == "batch",
+		syncInterval: cfg.SyncIntervalMS.AsDuration(),
+		client:       &http.Client{Timeout: timeout},
+		limiters:     make(map[string]*RateLimiter),
+		dirty:        make(map[*peerLimiter]struct{}),
+	}
+	for _, o := range options {
+		o(c)
+	}
+	c.logger = logging.Component(c.logger, "cluster")
+	if c.syncInterval <= 0 {
+		c.syncInterval = defaultPeerSyncInterval
+	}
+
+	if c.batch {
+		// Start in separate goroutine periodical task with sending of batched hits
+		go c.flushRoutine(ctx)
+	}
+	return c, nil
+}
+
+func (c *Cluster) register(name string, rl *RateLimiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limiters[name] = rl
+}
+
+// wrap makes buckets of the named limiter cluster-wide
+func (c *Cluster) wrap(name string, newAlgorithm AlgorithmFactory, logger *slog.Logger) AlgorithmFactory {
+	return func(ctx context.Context, key string) Algorithm {
+		return &peerLimiter{
+			cluster:      c,
+			logger:       logger,
+			limiter:      name,
+			key:          key,
+			owner:        c.ring.Owner(key),
+			ctx:          ctx,
+			newAlgorithm: newAlgorithm,
+		}
+	}
+}
+
+// Handler serves requests of the other replicas, it must be available on the self address.
+// Requests without the shared secret are rejected.
+func (c *Cluster) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+PeerHitsPath, c.handleHits)
+	return mux
+}
+
+func (c *Cluster) handleHits(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(PeerSecretHeader)), c.secret) != 1 {
+		http.Error(w, "Invalid peer secret", http.StatusUnauthorized)
+		return
+	}
+	var hits []peerHit
+	if err := json.NewDecoder(r.Body).Decode(&hits); err != nil {
+		http.Error(w, "Invalid hits: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, hit := range hits {
+		if hit.Hits < 0 {
+			// Negative hits would add tokens to the bucket
+			http.Error(w, fmt.Sprintf("Invalid hits %d of key %q", hit.Hits, hit.Key), http.StatusBadRequest)
+			return
+		}
+	}
+
+	decisions := make([]peerDecision, 0, len(hits))
+	c.mu.RLock()
+	for _, hit := range hits {
+		rl, ok := c.limiters[hit.Limiter]
+		if !ok {
+			c.mu.RUnlock()
+			http.Error(w, fmt.Sprintf("Unknown limiter %q", hit.Limiter), http.StatusNotFound)
+			return
+		}
+		decision := rl.decideOwned(hit.Key, hit.Hits, hit.Applied)
+		decisions = append(decisions, peerDecision(decision))
+	}
+	c.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(decisions); err != nil {
+		c.logger.Warn("Failed to return hits decisions", logging.ErrorKey, err)
+	}
+}
+
+// send applies hits on the owner and returns its decisions in the same order
+func (c *Cluster) send(ctx context.Context, owner string, hits []peerHit) ([]models.RateLimitDecision, error) {
+	body, err := json.Marshal(hits)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+owner+PeerHitsPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(PeerSecretHeader, string(c.secret))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer %s answered with status %d", owner, resp.StatusCode)
+	}
+
+	var wire []peerDecision
+	if err := json.NewDecoder(resp.Body).Decode(&wire); err != nil {
+		return nil, fmt.Errorf("invalid answer of peer %s: %w", owner, err)
+	}
+	if len(wire) != len(hits) {
+		return nil, fmt.Errorf("peer %s answered %d decisions for %d hits", owner, len(wire), len(hits))
+	}
+	decisions := make([]models.RateLimitDecision, len(wire))
+	for i, d := range wire {
+		decisions[i] = models.RateLimitDecision(d)
+	}
+	return decisions, nil
+}
+
+func (c *Cluster) markDirty(pl *peerLimiter) {
+	c.dirtyMu.Lock()
+	c.dirty[pl] = struct{}{}
+	c.dirtyMu.Unlock()
+}
+
+func (c *Cluster) flushRoutine(ctx context.Context) {
+	ticker := time.NewTicker(c.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Do not lose hits allowed since the last flush
+			flushCtx, cancel := context.WithTimeout(context.Background(), c.client.Timeout)
+			c.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			c.flush(ctx)
+		}
+	}
+}
+
+// flush sends hits of every dirty bucket to its owner, one request per owner
+func (c *Cluster) flush(ctx context.Context) {
+	c.dirtyMu.Lock()
+	dirty := c.dirty
+	c.dirty = make(map[*peerLimiter]struct{})
+	c.dirtyMu.Unlock()
+
+	byOwner := make(map[string][]batchedHits)
+	for pl := range dirty {
+		hits := pl.takePending()
+		if hits == 0 {
+			// The bucket was only rejecting: refresh it from the owner on the next request
+			pl.forget()
+			continue
+		}
+		byOwner[pl.owner] = append(byOwner[pl.owner], batchedHits{limiter: pl, hits: hits})
+	}
+
+	var wg sync.WaitGroup
+	for owner, batch := range byOwner {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.flushOwner(ctx, owner, batch)
+		}()
+	}
+	wg.Wait()
+}
+
+type batchedHits struct {
+	limiter *peerLimiter
+	hits    int
+}
+
+func (c *Cluster) flushOwner(ctx context.Context, owner string, batch []batchedHits) {
+	hits := make([]peerHit, len(batch))
+	for i, b := range batch {
+		hits[i] = peerHit{Limiter: b.limiter.limiter, Key: b.limiter.key, Hits: b.hits, Applied: true}
+	}
+
+	decisions, err := c.send(ctx, owner, hits)
+	if err != nil {
+		// Hits are dropped, buckets ask the owner (or fall back to local limit) on the next request
+		c.logger.Warn("Failed to send batched rate limit hits",
+			"hits", len(hits), "owner", owner, logging.ErrorKey, err)
+		for _, b := range batch {
+			b.limiter.forget()
+		}
+		return
+	}
+	for i, b := range batch {
+		b.limiter.update(decisions[i])
+	}
+}
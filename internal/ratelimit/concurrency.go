@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// ConcurrencyLimiter caps number of simultaneous in-flight requests of every client.
+// Unlike rate limiters it has no state over time: client entry exists only while it has requests in flight.
+type ConcurrencyLimiter struct {
+	maxInFlight int
+	// limits returns limit of the client, 0 means no limit
+	limits func(key string) int
+	shards []*concurrencyShard
+	seed   maphash.Seed
+}
+
+type concurrencyShard struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// WithInFlightLimits sets limit per client instead of the same one for everybody, 0 means no limit
+func WithInFlightLimits(limits func(key string) int) func(*ConcurrencyLimiter) {
+	return func(cl *ConcurrencyLimiter) {
+		cl.limits = limits
+	}
+}
+
+func NewConcurrencyLimiter(maxInFlight int, options ...func(*ConcurrencyLimiter)) *ConcurrencyLimiter {
+	cl := &ConcurrencyLimiter{
+		maxInFlight: maxInFlight,
+		shards:      make([]*concurrencyShard, defaultShards),
+		seed:        maphash.MakeSeed(),
+	}
+	cl.limits = func(string) int { return cl.maxInFlight }
+	for _, o := range options {
+		o(cl)
+	}
+	for i := range cl.shards {
+		cl.shards[i] = &concurrencyShard{inFlight: make(map[string]int)}
+	}
+	return cl
+}
+
+func (cl *ConcurrencyLimiter) shardFor(key string) *concurrencyShard {
+	return cl.shards[maphash.String(cl.seed, key)%uint64(len(cl.shards))]
+}
+
+// Acquire takes in-flight slot of the client. If it is taken, release must be called
+// exactly once when the request is finished, repeated calls are no-op.
+func (cl *ConcurrencyLimiter) Acquire(key string) (release func(), ok bool) {
+	limit := cl.limits(key)
+	if limit <= 0 {
+		return func() {}, true
+	}
+	shard := cl.shardFor(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.inFlight[key] >= limit {
+		return nil, false
+	}
+	shard.inFlight[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			shard.mu.Lock()
+			defer shard.mu.Unlock()
+			if shard.inFlight[key] <= 1 {
+				delete(shard.inFlight, key)
+			} else {
+				shard.inFlight[key]--
+			}
+		})
+	}, true
+}
+
+// Limit returns default max number of in-flight requests of a client
+func (cl *ConcurrencyLimiter) Limit() int {
+	return cl.maxInFlight
+}
+
+// InFlight returns number of requests of the client being processed now
+func (cl *ConcurrencyLimiter) InFlight(key string) int {
+	shard := cl.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.inFlight[key]
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiter_AcquireRelease(t *testing.T) {
+	t.Parallel()
+	cl := NewConcurrencyLimiter(2)
+
+	releaseFirst, ok := cl.Acquire("client")
+	require.True(t, ok)
+	releaseSecond, ok := cl.Acquire("client")
+	require.True(t, ok)
+	_, ok = cl.Acquire("client")
+	require.False(t, ok, "third in-flight request must be rejected")
+
+	_, ok = cl.Acquire("other")
+	require.True(t, ok, "clients are limited independently")
+
+	releaseFirst()
+	releaseFirst()
+	require.Equal(t, 1, cl.InFlight("client"), "repeated release must not free foreign slot")
+
+	_, ok = cl.Acquire("client")
+	require.True(t, ok)
+	releaseSecond()
+}
+
+func TestConcurrencyLimiter_ForgetsIdleClients(t *testing.T) {
+	t.Parallel()
+	cl := NewConcurrencyLimiter(1)
+
+	release, ok := cl.Acquire("client")
+	require.True(t, ok)
+	release()
+
+	shard := cl.shardFor("client")
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	require.Empty(t, shard.inFlight)
+}
+
+func TestConcurrencyLimiter_Concurrent(t *testing.T) {
+	t.Parallel()
+	const limit = 5
+	cl := NewConcurrencyLimiter(limit)
+
+	var (
+		wg      sync.WaitGroup
+		current atomic.Int64
+		peak    atomic.Int64
+	)
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, ok := cl.Acquire("client")
+			if !ok {
+				return
+			}
+			defer release()
+			n := current.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			current.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	require.LessOrEqual(t, peak.Load(), int64(limit))
+	require.Zero(t, cl.InFlight("client"))
+}
@@ -1,5 +1,22 @@
 package ratelimit
 
+import "github.com/zahartd/load_balancer/internal/models"
+
 type Algorithm interface {
-	Allow() bool
+	// DecideN atomically takes n units of the limit (e.g. tokens) or none of them
+	// and describes the state of the limit after it
+	DecideN(n int) models.RateLimitDecision
+}
+
+// IdleReporter is optionally implemented by algorithms which can tell
+// that their state is the same as a freshly created one (e.g. bucket is full again).
+// Such limiters can be safely evicted and recreated later.
+type IdleReporter interface {
+	Idle() bool
+}
+
+// Refunder is optionally implemented by algorithms which can give back units taken by the allowed decision,
+// e.g. when request delayed by shaping is cancelled before it is sent.
+type Refunder interface {
+	RefundN(n int)
 }
@@ -2,26 +2,109 @@ package ratelimit
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 
 	"github.com/zahartd/load_balancer/internal/config"
 	ratelimit_algorithms "github.com/zahartd/load_balancer/internal/ratelimit/algorithms"
+	"github.com/zahartd/load_balancer/internal/resp"
 )
 
-func CreateAlgorithm(ctx context.Context, algorithmType string, options any) Algorithm {
-	var algorithm Algorithm
+// AlgorithmFactory creates bucket for the client key
+type AlgorithmFactory func(ctx context.Context, key string) Algorithm
+
+// NewAlgorithmFactory prepares resources shared by all buckets of the algorithm (e.g. store connections).
+// They live until ctx is done. Buckets report errors (e.g. unavailable store) to the logger.
+// Options are checked here, so buckets are created without errors.
+func NewAlgorithmFactory(ctx context.Context, algorithmType string, options any, logger *slog.Logger) (AlgorithmFactory, error) {
+	switch algorithmType {
+	case "redis_token_bucket":
+		redisOptions, ok := options.(config.RedisTokenBucketLimiterOptions)
+		if !ok {
+			return nil, fmt.Errorf("invalid algorithm options: expected RedisTokenBucketLimiterOptions, but got %T", options)
+		}
+		client := resp.NewClient(
+			redisOptions.Address,
+			resp.WithPassword(redisOptions.Password),
+			resp.WithDB(redisOptions.DB),
+			resp.WithTimeout(redisOptions.TimeoutMS.AsDuration()),
+		)
+		go func() {
+			<-ctx.Done()
+			client.Close()
+		}()
+		return func(bucketCtx context.Context, key string) Algorithm {
+			return ratelimit_algorithms.NewRedisTokenBucketLimiter(
+				bucketCtx, client, key, redisOptions, ratelimit_algorithms.WithRedisLogger(logger),
+			)
+		}, nil
+	case "plan":
+		// Plans are shared with concurrency and quota limits, so they are passed instead of options
+		plans, ok := options.(*Plans)
+		if !ok {
+			return nil, fmt.Errorf("invalid algorithm options: expected *Plans, but got %T", options)
+		}
+		return func(_ context.Context, key string) Algorithm {
+			return ratelimit_algorithms.NewPlanLimiter(func() config.PlanLimits {
+				return plans.Limits(key)
+			})
+		}, nil
+	default:
+		return localAlgorithmFactory(algorithmType, options)
+	}
+}
+
+// CreateAlgorithm creates bucket of the local (not shared between replicas) algorithm
+func CreateAlgorithm(ctx context.Context, algorithmType string, options any) (Algorithm, error) {
+	newAlgorithm, err := localAlgorithmFactory(algorithmType, options)
+	if err != nil {
+		return nil, err
+	}
+	return newAlgorithm(ctx, ""), nil
+}
+
+// localAlgorithmFactory checks options of the local algorithm and returns constructor of its buckets
+func localAlgorithmFactory(algorithmType string, options any) (AlgorithmFactory, error) {
 	switch algorithmType {
 	case "token_bucket":
 		tokenBucketOptions, ok := options.(config.TokenBucketLimiterOptions)
 		if !ok {
-			log.Fatalf(
-				"Invalid algorithm options: expected TokenBucketLimiterOptions, but got %T\n",
+			return nil, fmt.Errorf("invalid algorithm options: expected TokenBucketLimiterOptions, but got %T", options)
+		}
+		return func(ctx context.Context, _ string) Algorithm {
+			return ratelimit_algorithms.NewTokenBucketLimiter(ctx, tokenBucketOptions)
+		}, nil
+	case "sliding_window_log":
+		slidingWindowLogOptions, ok := options.(config.SlidingWindowLogLimiterOptions)
+		if !ok {
+			return nil, fmt.Errorf(
+				"invalid algorithm options: expected SlidingWindowLogLimiterOptions, but got %T",
 				options,
 			)
 		}
-		algorithm = ratelimit_algorithms.NewTokenBucketLimiter(ctx, tokenBucketOptions)
+		return func(context.Context, string) Algorithm {
+			return ratelimit_algorithms.NewSlidingWindowLogLimiter(slidingWindowLogOptions)
+		}, nil
+	case "sliding_window_counter":
+		slidingWindowCounterOptions, ok := options.(config.SlidingWindowCounterLimiterOptions)
+		if !ok {
+			return nil, fmt.Errorf(
+				"invalid algorithm options: expected SlidingWindowCounterLimiterOptions, but got %T",
+				options,
+			)
+		}
+		return func(context.Context, string) Algorithm {
+			return ratelimit_algorithms.NewSlidingWindowCounterLimiter(slidingWindowCounterOptions)
+		}, nil
+	case "gcra":
+		gcraOptions, ok := options.(config.GCRALimiterOptions)
+		if !ok {
+			return nil, fmt.Errorf("invalid algorithm options: expected GCRALimiterOptions, but got %T", options)
+		}
+		return func(context.Context, string) Algorithm {
+			return ratelimit_algorithms.NewGCRALimiter(gcraOptions)
+		}, nil
 	default:
-		log.Fatalf("Uknown algorithm type type: %s\n", algorithmType)
+		return nil, fmt.Errorf("unknown algorithm type: %s", algorithmType)
 	}
-	return algorithm
 }
@@ -1,47 +1,339 @@
 package ratelimit
 
 import (
+	"container/list"
 	"context"
-	"log"
+	"hash/maphash"
+	"log/slog"
 	"sync"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/models"
+)
+
+const (
+	defaultIdleTTL       = 10 * time.Minute
+	defaultSweepInterval = time.Minute
+	defaultMaxClients    = 100_000
+	defaultShards        = 64
 )
 
+// limiterEntry is a single client bucket tracked by the RateLimiter
+type limiterEntry struct {
+	key       string
+	algorithm Algorithm
+	// cancel stops all resources (e.g. refill goroutine) owned by the bucket
+	cancel   context.CancelFunc
+	lastSeen int64 // unix nanoseconds
+	elem     *list.Element
+}
+
 type RateLimiter struct {
-	limiters    map[string]Algorithm
+	ctx         context.Context
 	limiterType string
 	options     any
-	mu          sync.RWMutex
+	// newAlgorithm creates bucket for a new client
+	newAlgorithm AlgorithmFactory
+
+	idleTTL       time.Duration
+	sweepInterval time.Duration
+	maxClients    int
+
+	// Buckets are spread by key hash over independent shards,
+	// so requests of different clients rarely wait for each other
+	shards []*limiterShard
+	seed   maphash.Seed
+
+	// cluster shares limits with other balancer replicas, the limiter is known there by name
+	cluster *Cluster
+	name    string
+
+	logger *slog.Logger
+}
+
+// limiterShard is LRU cache of client buckets:
+// the front of the list is the most recently used bucket
+type limiterShard struct {
+	mu         sync.Mutex
+	limiters   map[string]*limiterEntry
+	lru        *list.List
+	maxClients int
+}
+
+// WithIdleTTL sets how long bucket can stay unused before it becomes a candidate for eviction
+func WithIdleTTL(ttl time.Duration) func(*RateLimiter) {
+	return func(rl *RateLimiter) {
+		if ttl > 0 {
+			rl.idleTTL = ttl
+		}
+	}
+}
+
+// WithSweepInterval sets how often background sweeper looks for idle buckets
+func WithSweepInterval(interval time.Duration) func(*RateLimiter) {
+	return func(rl *RateLimiter) {
+		if interval > 0 {
+			rl.sweepInterval = interval
+		}
+	}
+}
+
+// WithMaxClients sets cap on tracked client keys, after that least recently used bucket is evicted.
+// Cap is split evenly between shards and LRU order is kept per shard, so with uneven key distribution
+// bucket can be evicted while total number of them is still below the cap
+func WithMaxClients(n int) func(*RateLimiter) {
+	return func(rl *RateLimiter) {
+		if n > 0 {
+			rl.maxClients = n
+		}
+	}
+}
+
+// WithCluster makes limits global for all replicas of the cluster.
+// Name identifies the limiter between replicas, so it must be the same on all of them.
+func WithCluster(cluster *Cluster, name string) func(*RateLimiter) {
+	return func(rl *RateLimiter) {
+		rl.cluster = cluster
+		rl.name = name
+	}
+}
+
+// WithLogger sets logger of the limiter, by default it is slog.Default()
+func WithLogger(logger *slog.Logger) func(*RateLimiter) {
+	return func(rl *RateLimiter) {
+		rl.logger = logger
+	}
+}
+
+// withShards sets number of registry shards, one shard means single global lock
+func withShards(n int) func(*RateLimiter) {
+	return func(rl *RateLimiter) {
+		if n > 0 {
+			rl.shards = make([]*limiterShard, n)
+		}
+	}
 }
 
-func New(limiterType string, limiterOptions any) *RateLimiter {
-	return &RateLimiter{
-		limiterType: limiterType,
-		options:     limiterOptions,
-		limiters:    make(map[string]Algorithm),
+func New(ctx context.Context, limiterType string, limiterOptions any, options ...func(*RateLimiter)) (*RateLimiter, error) {
+	rl := &RateLimiter{
+		ctx:           ctx,
+		limiterType:   limiterType,
+		options:       limiterOptions,
+		idleTTL:       defaultIdleTTL,
+		sweepInterval: defaultSweepInterval,
+		maxClients:    defaultMaxClients,
+		shards:        make([]*limiterShard, defaultShards),
+		seed:          maphash.MakeSeed(),
 	}
+	for _, o := range options {
+		o(rl)
+	}
+	rl.logger = logging.Component(rl.logger, "ratelimit").With("algorithm", limiterType)
+	newAlgorithm, err := NewAlgorithmFactory(ctx, limiterType, limiterOptions, rl.logger)
+	if err != nil {
+		return nil, err
+	}
+	rl.newAlgorithm = newAlgorithm
+	if rl.cluster != nil {
+		rl.newAlgorithm = rl.cluster.wrap(rl.name, rl.newAlgorithm, rl.logger)
+		rl.cluster.register(rl.name, rl)
+	}
+
+	// Cap is split between shards, so every shard can be evicted independently
+	// and the total never exceeds maxClients (but full shard evicts before the total reaches it)
+	rl.shards = rl.shards[:min(len(rl.shards), rl.maxClients)]
+	for i := range rl.shards {
+		shardMaxClients := rl.maxClients / len(rl.shards)
+		if i < rl.maxClients%len(rl.shards) {
+			shardMaxClients++
+		}
+		rl.shards[i] = &limiterShard{
+			limiters:   make(map[string]*limiterEntry),
+			lru:        list.New(),
+			maxClients: shardMaxClients,
+		}
+	}
+
+	// Start in separate goroutine periodical task with idle buckets eviction
+	go rl.sweepRoutine(ctx)
+
+	return rl, nil
+}
+
+func (rl *RateLimiter) shardFor(key string) *limiterShard {
+	return rl.shards[maphash.String(rl.seed, key)%uint64(len(rl.shards))]
 }
 
 func (rl *RateLimiter) getLimiter(ctx context.Context, key string) Algorithm {
+	now := time.Now().UnixNano()
+	shard := rl.shardFor(key)
+
+	// Lookup and creation happen under the same lock,
+	// so there is exactly one bucket per key even under concurrent misses
+	shard.mu.Lock()
+
 	// Check if it already created limiter for this client
-	rl.mu.RLock()
-	if l, exists := rl.limiters[key]; exists {
-		rl.mu.RUnlock()
-		log.Printf("Use rate limiter (type=%s) for %s", rl.limiterType, key)
-		return l
+	if e, exists := shard.limiters[key]; exists {
+		e.lastSeen = now
+		shard.lru.MoveToFront(e.elem)
+		shard.mu.Unlock()
+		rl.logger.DebugContext(ctx, "Use rate limiter", logging.ClientIDKey, key)
+		return e.algorithm
+	}
+
+	// Create new limmiter, bucket resources live until it is evicted
+	bucketCtx, cancel := context.WithCancel(rl.ctx)
+	e := &limiterEntry{
+		key:       key,
+		algorithm: rl.newAlgorithm(bucketCtx, key),
+		cancel:    cancel,
+		lastSeen:  now,
+	}
+	e.elem = shard.lru.PushFront(e)
+	shard.limiters[key] = e
+
+	// Keep memory bounded: drop least recently used buckets over the cap
+	var evicted []string
+	for shard.lru.Len() > shard.maxClients {
+		oldest := shard.lru.Back().Value.(*limiterEntry)
+		shard.evictLocked(oldest)
+		evicted = append(evicted, oldest.key)
 	}
-	rl.mu.RUnlock()
+	shard.mu.Unlock()
 
-	// Create new limmiter
-	l := CreateAlgorithm(ctx, rl.limiterType, rl.options)
-	rl.mu.Lock()
-	rl.limiters[key] = l
-	rl.mu.Unlock()
+	for _, k := range evicted {
+		rl.logger.DebugContext(ctx, "Evicted least recently used rate limiter", logging.ClientIDKey, k)
+	}
+	rl.logger.DebugContext(ctx, "Created rate limiter", logging.ClientIDKey, key)
+	return e.algorithm
+}
 
-	log.Printf("Created rate limiter (type=%s) for %s", rl.limiterType, key)
-	return l
+// evictLocked removes bucket and releases its resources, shard.mu must be held
+func (shard *limiterShard) evictLocked(e *limiterEntry) {
+	shard.lru.Remove(e.elem)
+	delete(shard.limiters, e.key)
+	e.cancel()
 }
 
-func (rl *RateLimiter) AllowRequest(ctx context.Context, key string) (bool, error) {
-	limiter := rl.getLimiter(ctx, key)
-	return limiter.Allow(), nil
+func (rl *RateLimiter) sweepRoutine(ctx context.Context) {
+	ticker := time.NewTicker(rl.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rl.sweep(time.Now())
+		}
+	}
+}
+
+// sweep evicts buckets which were not used for idleTTL and are full again,
+// so dropping them is indistinguishable for the client from keeping them
+func (rl *RateLimiter) sweep(now time.Time) {
+	deadline := now.Add(-rl.idleTTL).UnixNano()
+	for _, shard := range rl.shards {
+		evicted := shard.sweep(deadline)
+		if evicted > 0 {
+			rl.logger.Info("Evicted idle rate limiters", "count", evicted)
+		}
+	}
+}
+
+func (shard *limiterShard) sweep(deadline int64) int {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// Walk from the least recently used side and stop at the first fresh bucket
+	evicted := 0
+	for elem := shard.lru.Back(); elem != nil; {
+		e := elem.Value.(*limiterEntry)
+		if e.lastSeen > deadline {
+			break
+		}
+		prev := elem.Prev()
+		if idle, ok := e.algorithm.(IdleReporter); !ok || idle.Idle() {
+			shard.evictLocked(e)
+			evicted++
+		}
+		elem = prev
+	}
+	return evicted
+}
+
+// Len returns number of currently tracked client buckets
+func (rl *RateLimiter) Len() int {
+	total := 0
+	for _, shard := range rl.shards {
+		shard.mu.Lock()
+		total += len(shard.limiters)
+		shard.mu.Unlock()
+	}
+	return total
+}
+
+// AllowRequest checks request of the client against its limit.
+// For shaping algorithms it blocks until the reserved slot or ctx cancellation.
+func (rl *RateLimiter) AllowRequest(ctx context.Context, key string) (models.RateLimitDecision, error) {
+	return rl.AllowRequestN(ctx, key, 1)
+}
+
+// AllowRequestN is AllowRequest for request which costs n units of the limit.
+// If ctx is cancelled while request is delayed, reserved units are given back and request is rejected with ctx error.
+func (rl *RateLimiter) AllowRequestN(ctx context.Context, key string, n int) (models.RateLimitDecision, error) {
+	algorithm := rl.getLimiter(ctx, key)
+	decision := algorithm.DecideN(n)
+	if !decision.Allowed || decision.Delay == 0 {
+		return decision, nil
+	}
+
+	timer := time.NewTimer(decision.Delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		if refunder, ok := algorithm.(Refunder); ok {
+			refunder.RefundN(n)
+		}
+		decision.Allowed = false
+		return decision, ctx.Err()
+	case <-timer.C:
+		return decision, nil
+	}
+}
+
+// DecideN is AllowRequestN which does not wait: delay of shaping algorithms is only reported in the decision
+func (rl *RateLimiter) DecideN(ctx context.Context, key string, n int) models.RateLimitDecision {
+	return rl.getLimiter(ctx, key).DecideN(n)
+}
+
+// RefundN gives back n units taken by the allowed request of the client, e.g. when request is rejected
+// by another limit. Algorithms which cannot give units back keep them.
+func (rl *RateLimiter) RefundN(key string, n int) {
+	shard := rl.shardFor(key)
+	shard.mu.Lock()
+	e, exists := shard.limiters[key]
+	shard.mu.Unlock()
+	if !exists {
+		// Bucket is already evicted, there is nothing to give back
+		return
+	}
+	if refunder, ok := e.algorithm.(Refunder); ok {
+		refunder.RefundN(n)
+	}
+}
+
+// decideOwned applies hits sent by other replica to the local bucket of the key
+func (rl *RateLimiter) decideOwned(key string, n int, applied bool) models.RateLimitDecision {
+	algorithm := rl.getLimiter(rl.ctx, key)
+	if pl, ok := algorithm.(*peerLimiter); ok {
+		algorithm = pl.localAlgorithm()
+	}
+
+	decision := algorithm.DecideN(n)
+	if applied && !decision.Allowed && decision.Remaining > 0 {
+		// Requests are already served by the sender, take at least what is left
+		decision = algorithm.DecideN(decision.Remaining)
+	}
+	return decision
 }
@@ -0,0 +1,267 @@
+package ratelimit
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/models"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestLimiter(t *testing.T, capacity int, options ...func(*RateLimiter)) *RateLimiter {
+	t.Helper()
+	rl, err := New(t.Context(), "token_bucket", config.TokenBucketLimiterOptions{
+		DefaultCapacity:         capacity,
+		DefaultRefillIntervalMS: config.DurationMs(10),
+	}, options...)
+	require.NoError(t, err)
+	return rl
+}
+
+func TestNew_InvalidAlgorithm(t *testing.T) {
+	t.Parallel()
+	_, err := New(t.Context(), "leaky", config.TokenBucketLimiterOptions{})
+	require.ErrorContains(t, err, "unknown algorithm type")
+	_, err = New(t.Context(), "gcra", config.TokenBucketLimiterOptions{})
+	require.ErrorContains(t, err, "expected GCRALimiterOptions")
+	_, err = New(t.Context(), "plan", nil)
+	require.ErrorContains(t, err, "expected *Plans")
+}
+
+func TestRateLimiter_EvictsIdleFullBuckets(t *testing.T) {
+	t.Parallel()
+	rl := newTestLimiter(t, 2, WithIdleTTL(50*time.Millisecond), WithSweepInterval(10*time.Millisecond))
+
+	decision, err := rl.AllowRequest(t.Context(), "client")
+	require.NoError(t, err)
+	require.True(t, decision.Allowed)
+	require.Equal(t, 1, rl.Len())
+
+	require.Eventually(t, func() bool { return rl.Len() == 0 }, time.Second, 10*time.Millisecond,
+		"idle bucket should be evicted after it is refilled")
+}
+
+func TestRateLimiter_KeepsNotRefilledBuckets(t *testing.T) {
+	t.Parallel()
+	rl, err := New(t.Context(), "token_bucket", config.TokenBucketLimiterOptions{
+		DefaultCapacity:         1,
+		DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	}, WithIdleTTL(time.Millisecond))
+	require.NoError(t, err)
+
+	decision, _ := rl.AllowRequest(t.Context(), "client")
+	require.True(t, decision.Allowed)
+
+	// Bucket is empty, so forgetting it would give client fresh tokens
+	rl.sweep(time.Now().Add(time.Hour))
+	require.Equal(t, 1, rl.Len())
+
+	decision, _ = rl.AllowRequest(t.Context(), "client")
+	require.False(t, decision.Allowed)
+}
+
+func TestRateLimiter_MaxClientsLRU(t *testing.T) {
+	t.Parallel()
+	rl := newTestLimiter(t, 1, WithMaxClients(2), withShards(1))
+
+	_, _ = rl.AllowRequest(t.Context(), "a")
+	_, _ = rl.AllowRequest(t.Context(), "b")
+	// Touch "a" so "b" becomes the least recently used one
+	_, _ = rl.AllowRequest(t.Context(), "a")
+	_, _ = rl.AllowRequest(t.Context(), "c")
+
+	require.Equal(t, 2, rl.Len())
+	shard := rl.shards[0]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	require.Contains(t, shard.limiters, "a")
+	require.Contains(t, shard.limiters, "c")
+	require.NotContains(t, shard.limiters, "b")
+}
+
+func TestRateLimiter_MaxClientsAcrossShards(t *testing.T) {
+	t.Parallel()
+	rl := newTestLimiter(t, 1, WithMaxClients(100))
+
+	for i := range 1000 {
+		_, _ = rl.AllowRequest(t.Context(), strconv.Itoa(i))
+	}
+
+	require.LessOrEqual(t, rl.Len(), 100)
+}
+
+func TestRateLimiter_SingleBucketPerKey(t *testing.T) {
+	t.Parallel()
+	const capacity = 5
+	rl, err := New(t.Context(), "token_bucket", config.TokenBucketLimiterOptions{
+		DefaultCapacity:         capacity,
+		DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	})
+	require.NoError(t, err)
+
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int64
+	)
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if decision, _ := rl.AllowRequest(t.Context(), "client"); decision.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, 1, rl.Len(), "concurrent misses must not create duplicate buckets")
+	require.EqualValues(t, capacity, allowed.Load(), "all requests must share one bucket")
+}
+
+type allowAll struct{}
+
+func (allowAll) DecideN(int) models.RateLimitDecision { return models.RateLimitDecision{Allowed: true} }
+
+// baselineRegistry is copy of the registry as it was before LRU and sharding:
+// one map under RWMutex, bucket is created outside of the lock
+type baselineRegistry struct {
+	limiters     map[string]Algorithm
+	limiterType  string
+	newAlgorithm func() Algorithm
+	mu           sync.RWMutex
+}
+
+func (rl *baselineRegistry) getLimiter(key string) Algorithm {
+	rl.mu.RLock()
+	if l, exists := rl.limiters[key]; exists {
+		rl.mu.RUnlock()
+		log.Printf("Use rate limiter (type=%s) for %s", rl.limiterType, key)
+		return l
+	}
+	rl.mu.RUnlock()
+
+	l := rl.newAlgorithm()
+	rl.mu.Lock()
+	rl.limiters[key] = l
+	rl.mu.Unlock()
+
+	log.Printf("Created rate limiter (type=%s) for %s", rl.limiterType, key)
+	return l
+}
+
+// BenchmarkRateLimiter compares the original RWMutex map registry with the sharded LRU one
+// under parallel load. Buckets are stubbed to measure only registry overhead.
+func BenchmarkRateLimiter(b *testing.B) {
+	log.SetOutput(io.Discard)
+
+	workloads := []struct {
+		name string
+		keys int
+	}{
+		{"hot_keys", 16},
+		{"high_cardinality", 100_000},
+	}
+	run := func(b *testing.B, keys int, allow func(key string)) {
+		names := make([]string, keys)
+		for i := range names {
+			names[i] = "client-" + strconv.Itoa(i)
+		}
+		b.SetParallelism(16)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := rand.IntN(len(names))
+			for pb.Next() {
+				allow(names[i%len(names)])
+				i++
+			}
+		})
+	}
+
+	for _, wl := range workloads {
+		b.Run("baseline/"+wl.name, func(b *testing.B) {
+			rl := &baselineRegistry{
+				limiters:     make(map[string]Algorithm),
+				limiterType:  "token_bucket",
+				newAlgorithm: func() Algorithm { return allowAll{} },
+			}
+			run(b, wl.keys, func(key string) { rl.getLimiter(key).DecideN(1) })
+		})
+		b.Run("sharded/"+wl.name, func(b *testing.B) {
+			rl, err := New(b.Context(), "token_bucket", config.TokenBucketLimiterOptions{})
+			require.NoError(b, err)
+			rl.newAlgorithm = func(context.Context, string) Algorithm { return allowAll{} }
+			run(b, wl.keys, func(key string) { _, _ = rl.AllowRequest(b.Context(), key) })
+		})
+	}
+}
+
+func TestRateLimiter_ShapingDelaysRequest(t *testing.T) {
+	t.Parallel()
+	rl, err := New(t.Context(), "gcra", config.GCRALimiterOptions{
+		Rate:       10,
+		PeriodMS:   config.DurationMs(1000),
+		Burst:      1,
+		Shaping:    true,
+		MaxDelayMS: config.DurationMs(150),
+	})
+	require.NoError(t, err)
+
+	decision, err := rl.AllowRequest(t.Context(), "client")
+	require.NoError(t, err)
+	require.True(t, decision.Allowed)
+
+	start := time.Now()
+	decision, err = rl.AllowRequest(t.Context(), "client")
+	require.NoError(t, err)
+	require.True(t, decision.Allowed, "second request should be delayed, not rejected")
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	decision, err = rl.AllowRequest(ctx, "client")
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, decision.Allowed)
+}
+
+func TestRateLimiter_ShapingCancelRefunds(t *testing.T) {
+	t.Parallel()
+	rl, err := New(t.Context(), "gcra", config.GCRALimiterOptions{
+		Rate:       10,
+		PeriodMS:   config.DurationMs(1000),
+		Burst:      1,
+		Shaping:    true,
+		MaxDelayMS: config.DurationMs(1000),
+	})
+	require.NoError(t, err)
+
+	decision, err := rl.AllowRequest(t.Context(), "client")
+	require.NoError(t, err)
+	require.True(t, decision.Allowed)
+
+	for range 3 {
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		decision, err = rl.AllowRequest(ctx, "client")
+		cancel()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.False(t, decision.Allowed)
+		require.LessOrEqual(t, decision.Delay, 100*time.Millisecond, "slot of cancelled request is given back")
+	}
+}
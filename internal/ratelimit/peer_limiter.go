@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/models"
+)
+
+// peerLimiter is client bucket of the cluster-wide limiter.
+// Owner decides with its local bucket, the other replicas ask the owner.
+type peerLimiter struct {
+	cluster *Cluster
+	logger  *slog.Logger
+	limiter string
+	key     string
+	owner   string
+
+	// Local bucket is created lazily: not owned keys need it only when the owner is unreachable
+	ctx          context.Context
+	newAlgorithm AlgorithmFactory
+	localMu      sync.Mutex
+	local        Algorithm
+
+	// Batch mode: last state received from the owner minus hits allowed since then
+	mu      sync.Mutex
+	known   bool
+	state   models.RateLimitDecision
+	pending int
+}
+
+func (pl *peerLimiter) localAlgorithm() Algorithm {
+	pl.localMu.Lock()
+	defer pl.localMu.Unlock()
+	if pl.local == nil {
+		pl.local = pl.newAlgorithm(pl.ctx, pl.key)
+	}
+	return pl.local
+}
+
+func (pl *peerLimiter) DecideN(n int) models.RateLimitDecision {
+	if pl.owner == pl.cluster.self {
+		return pl.localAlgorithm().DecideN(n)
+	}
+	if pl.cluster.batch {
+		if decision, ok := pl.decideCached(n); ok {
+			return decision
+		}
+	}
+
+	decisions, err := pl.cluster.send(pl.ctx, pl.owner, []peerHit{{Limiter: pl.limiter, Key: pl.key, Hits: n}})
+	if err != nil {
+		// Limit becomes per replica until the owner is back
+		pl.logger.Warn("Rate limit owner is unavailable",
+			"owner", pl.owner, logging.ClientIDKey, pl.key, logging.ErrorKey, err)
+		return pl.localAlgorithm().DecideN(n)
+	}
+	if pl.cluster.batch {
+		pl.update(decisions[0])
+		pl.cluster.markDirty(pl)
+	}
+	return decisions[0]
+}
+
+// decideCached decides from the last known state of the owner bucket, ok is false if it is unknown
+func (pl *peerLimiter) decideCached(n int) (models.RateLimitDecision, bool) {
+	pl.mu.Lock()
+	if !pl.known {
+		pl.mu.Unlock()
+		return models.RateLimitDecision{}, false
+	}
+	decision := pl.state
+	decision.Allowed = pl.state.Remaining >= n
+	decision.Delay = 0
+	if decision.Allowed {
+		pl.state.Remaining -= n
+		pl.pending += n
+		decision.Remaining = pl.state.Remaining
+		decision.RetryAfter = 0
+	}
+	pl.mu.Unlock()
+
+	pl.cluster.markDirty(pl)
+	return decision, true
+}
+
+// RefundN gives back hits decided locally: by the own bucket or from the cached state and not sent yet.
+// Hits already applied by the owner are kept.
+func (pl *peerLimiter) RefundN(n int) {
+	if pl.owner == pl.cluster.self {
+		if refunder, ok := pl.localAlgorithm().(Refunder); ok {
+			refunder.RefundN(n)
+		}
+		return
+	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	n = min(n, pl.pending)
+	pl.pending -= n
+	pl.state.Remaining += n
+}
+
+// takePending returns hits to send to the owner
+func (pl *peerLimiter) takePending() int {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pending := pl.pending
+	pl.pending = 0
+	return pending
+}
+
+// update sets state received from the owner, hits allowed while it was in flight are not there yet
+func (pl *peerLimiter) update(decision models.RateLimitDecision) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.known = true
+	pl.state = decision
+	pl.state.Remaining = max(decision.Remaining-pl.pending, 0)
+}
+
+// forget drops cached state, so the next request goes to the owner
+func (pl *peerLimiter) forget() {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.known = false
+}
+
+// Idle reports whether all hits are sent to the owner and local bucket (if any) is full
+func (pl *peerLimiter) Idle() bool {
+	pl.mu.Lock()
+	pending := pl.pending
+	pl.mu.Unlock()
+	if pending > 0 {
+		return false
+	}
+
+	pl.localMu.Lock()
+	local := pl.local
+	pl.localMu.Unlock()
+	if reporter, ok := local.(IdleReporter); ok {
+		return reporter.Idle()
+	}
+	return true
+}
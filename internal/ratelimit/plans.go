@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+// ErrUnknownPlan is returned on change of the plan which is not defined
+var ErrUnknownPlan = errors.New("unknown plan")
+
+// Plans resolves limits of clients by their plans. Plan definitions can be changed at runtime,
+// limiters read limits on every request, so changes apply to all live buckets at once.
+type Plans struct {
+	mu          sync.RWMutex
+	definitions map[string]config.PlanLimits
+	defaultPlan string
+	clients     map[string]config.ClientPlanConfig
+}
+
+func NewPlans(cfg config.PlansConfig) (*Plans, error) {
+	for name, limits := range cfg.Definitions {
+		if err := validatePlanLimits(limits); err != nil {
+			return nil, fmt.Errorf("invalid plan %q: %w", name, err)
+		}
+	}
+	if _, ok := cfg.Definitions[cfg.Default]; !ok {
+		return nil, fmt.Errorf("default plan %q is not defined", cfg.Default)
+	}
+	for key, client := range cfg.Clients {
+		if _, ok := cfg.Definitions[client.Plan]; !ok {
+			return nil, fmt.Errorf("plan %q of client %s is not defined", client.Plan, key)
+		}
+	}
+	return &Plans{
+		definitions: maps.Clone(cfg.Definitions),
+		defaultPlan: cfg.Default,
+		clients:     maps.Clone(cfg.Clients),
+	}, nil
+}
+
+// Limits returns limits of the client plan with client overrides applied
+func (p *Plans) Limits(key string) config.PlanLimits {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	client, ok := p.clients[key]
+	if !ok {
+		return p.definitions[p.defaultPlan]
+	}
+	limits := p.definitions[client.Plan]
+	o := client.Overrides
+	if o.Rate != nil {
+		limits.Rate = *o.Rate
+	}
+	if o.PeriodMS != nil {
+		limits.PeriodMS = *o.PeriodMS
+	}
+	if o.Burst != nil {
+		limits.Burst = *o.Burst
+	}
+	if o.MaxInFlight != nil {
+		limits.MaxInFlight = *o.MaxInFlight
+	}
+	if o.QuotaLimit != nil {
+		limits.QuotaLimit = *o.QuotaLimit
+	}
+	return limits
+}
+
+// PlanOf returns name of the client plan
+func (p *Plans) PlanOf(key string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if client, ok := p.clients[key]; ok {
+		return client.Plan
+	}
+	return p.defaultPlan
+}
+
+// Configured reports whether the client is listed in the config, other clients get the default plan
+func (p *Plans) Configured(key string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.clients[key]
+	return ok
+}
+
+// Definitions returns copy of all plan definitions
+func (p *Plans) Definitions() map[string]config.PlanLimits {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return maps.Clone(p.definitions)
+}
+
+// validatePlanLimits rejects negative limits, zero ones mean no limit
+func validatePlanLimits(limits config.PlanLimits) error {
+	if limits.Rate < 0 || limits.PeriodMS < 0 || limits.Burst < 0 || limits.MaxInFlight < 0 || limits.QuotaLimit < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if limits.Rate > 0 && limits.PeriodMS <= 0 {
+		// Otherwise rate would be per nanosecond, so not limited at all
+		return errors.New("period_ms must be positive with rate")
+	}
+	return nil
+}
+
+// SetPlan replaces definition of the existing plan, clients of the plan get new limits with the next request
+func (p *Plans) SetPlan(name string, limits config.PlanLimits) error {
+	if err := validatePlanLimits(limits); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.definitions[name]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownPlan, name)
+	}
+	p.definitions[name] = limits
+	return nil
+}
+
+// MaxInFlight returns concurrency limit of the client, 0 means no limit
+func (p *Plans) MaxInFlight(key string) int {
+	return p.Limits(key).MaxInFlight
+}
+
+// QuotaLimit returns quota of the client, 0 means no limit
+func (p *Plans) QuotaLimit(key string) int {
+	return p.Limits(key).QuotaLimit
+}
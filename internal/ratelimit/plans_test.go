@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func newTestPlans(t *testing.T) *Plans {
+	t.Helper()
+	plans, err := NewPlans(config.PlansConfig{
+		Definitions: map[string]config.PlanLimits{
+			"free": {Rate: 1, PeriodMS: config.DurationMs(time.Hour.Milliseconds()), Burst: 2, MaxInFlight: 1, QuotaLimit: 100},
+			"pro":  {Rate: 10, PeriodMS: config.DurationMs(time.Hour.Milliseconds()), Burst: 5, MaxInFlight: 10},
+		},
+		Default: "free",
+		Clients: map[string]config.ClientPlanConfig{
+			"acme":   {Plan: "pro"},
+			"custom": {Plan: "pro", Overrides: config.PlanOverrides{Burst: ptr(50), QuotaLimit: ptr(1000)}},
+		},
+	})
+	require.NoError(t, err)
+	return plans
+}
+
+func TestPlans_Limits(t *testing.T) {
+	t.Parallel()
+	plans := newTestPlans(t)
+
+	require.Equal(t, "free", plans.PlanOf("anonymous"))
+	require.Equal(t, 2, plans.Limits("anonymous").Burst)
+	require.False(t, plans.Configured("anonymous"))
+	require.True(t, plans.Configured("acme"))
+	require.Equal(t, "pro", plans.PlanOf("acme"))
+	require.Equal(t, 5, plans.Limits("acme").Burst)
+
+	custom := plans.Limits("custom")
+	require.Equal(t, 50, custom.Burst, "override replaces plan field")
+	require.Equal(t, 1000, custom.QuotaLimit)
+	require.Equal(t, 10, custom.Rate, "not overridden fields are taken from plan")
+}
+
+func TestPlans_InvalidConfig(t *testing.T) {
+	t.Parallel()
+	_, err := NewPlans(config.PlansConfig{Definitions: map[string]config.PlanLimits{"free": {}}, Default: "basic"})
+	require.Error(t, err)
+	_, err = NewPlans(config.PlansConfig{
+		Definitions: map[string]config.PlanLimits{"free": {}},
+		Default:     "free",
+		Clients:     map[string]config.ClientPlanConfig{"acme": {Plan: "gold"}},
+	})
+	require.Error(t, err)
+	_, err = NewPlans(config.PlansConfig{Definitions: map[string]config.PlanLimits{"free": {Burst: -1}}, Default: "free"})
+	require.Error(t, err)
+	_, err = NewPlans(config.PlansConfig{Definitions: map[string]config.PlanLimits{"free": {Rate: 10}}, Default: "free"})
+	require.Error(t, err, "rate without period")
+}
+
+func TestPlans_SetPlan(t *testing.T) {
+	t.Parallel()
+	plans := newTestPlans(t)
+
+	require.ErrorIs(t, plans.SetPlan("gold", config.PlanLimits{Rate: 1, PeriodMS: 1000}), ErrUnknownPlan)
+	require.NotContains(t, plans.Definitions(), "gold")
+
+	err := plans.SetPlan("free", config.PlanLimits{Rate: 1, QuotaLimit: -1})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrUnknownPlan)
+	require.Error(t, plans.SetPlan("free", config.PlanLimits{Rate: 1}), "rate without period")
+	require.Equal(t, 100, plans.Limits("anonymous").QuotaLimit, "invalid limits are not applied")
+}
+
+func TestPlans_ChangeAppliesToLiveBuckets(t *testing.T) {
+	t.Parallel()
+	plans := newTestPlans(t)
+	rl, err := New(t.Context(), "plan", plans)
+	require.NoError(t, err)
+
+	allow := func(key string) bool {
+		decision, err := rl.AllowRequest(t.Context(), key)
+		require.NoError(t, err)
+		return decision.Allowed
+	}
+	require.True(t, allow("anonymous"))
+	require.True(t, allow("anonymous"))
+	require.False(t, allow("anonymous"), "burst of free plan is exhausted")
+
+	require.NoError(t, plans.SetPlan("free", config.PlanLimits{Rate: 1, PeriodMS: config.DurationMs(time.Hour.Milliseconds()), Burst: 5}))
+	require.Equal(t, 1, rl.Len(), "bucket is not recreated")
+	require.True(t, allow("anonymous"), "bigger burst is applied to the existing bucket")
+
+	require.NoError(t, plans.SetPlan("free", config.PlanLimits{}))
+	for range 100 {
+		require.True(t, allow("anonymous"), "plan without rate is not limited")
+	}
+}
+
+func TestPlans_ConcurrencyAndQuota(t *testing.T) {
+	t.Parallel()
+	plans := newTestPlans(t)
+
+	cl := NewConcurrencyLimiter(0, WithInFlightLimits(plans.MaxInFlight))
+	_, ok := cl.Acquire("anonymous")
+	require.True(t, ok)
+	_, ok = cl.Acquire("anonymous")
+	require.False(t, ok, "free plan allows one in-flight request")
+	for range 10 {
+		_, ok = cl.Acquire("acme")
+		require.True(t, ok)
+	}
+
+	quota, err := NewQuota(t.Context(), config.QuotaConfig{Period: "day"}, nil, WithQuotaLimits(plans.QuotaLimit))
+	require.NoError(t, err)
+	require.Equal(t, 99, quota.Consume("anonymous", 1).Remaining)
+	require.True(t, quota.Consume("acme", 1).Unlimited, "pro plan has no quota")
+	require.Equal(t, 999, quota.Consume("custom", 1).Remaining)
+}
@@ -0,0 +1,310 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/logging"
+)
+
+const defaultQuotaFlushInterval = 10 * time.Second
+
+// QuotaUsage is usage of the client quota in the current period
+type QuotaUsage struct {
+	Used        int       `json:"used"`
+	PeriodStart time.Time `json:"period_start"`
+}
+
+// QuotaDecision describes client quota after the request
+type QuotaDecision struct {
+	// Unlimited is set for clients without quota
+	Unlimited bool
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAt is start of the next period, when quota is renewed
+	ResetAt time.Time
+}
+
+// QuotaStore persists quota counters across restarts
+type QuotaStore interface {
+	Load() (map[string]QuotaUsage, error)
+	Save(usage map[string]QuotaUsage) error
+}
+
+// quotaEntry is counter of a single client tracked by the Quota
+type quotaEntry struct {
+	key   string
+	usage QuotaUsage
+	// elem is nil for pinned counters, they are not in the LRU list
+	elem *list.Element
+}
+
+// Quota limits number of requests of every client per calendar day or month, zero limit means no quota.
+// Periods are aligned to midnight (or the first day of month) in the configured timezone.
+// At most maxClients counters of not pinned clients are kept, over the cap the least recently used one
+// is dropped. Pinned clients (e.g. ones with configured plan) are never dropped, so flood of new keys
+// cannot renew their quota.
+type Quota struct {
+	limit int
+	// limits returns quota of the client, by default the same limit for everybody
+	limits func(key string) int
+	// pinned reports whether counter of the client must never be dropped
+	pinned        func(key string) bool
+	period        string
+	location      *time.Location
+	store         QuotaStore
+	flushInterval time.Duration
+	maxClients    int
+	now           func() time.Time
+	logger        *slog.Logger
+
+	mu sync.Mutex
+	// usage is LRU cache of counters: the front of the list is the most recently used one
+	usage map[string]*quotaEntry
+	lru   *list.List
+	dirty bool
+}
+
+// WithQuotaLimits sets quota per client instead of the same one for everybody
+func WithQuotaLimits(limits func(key string) int) func(*Quota) {
+	return func(q *Quota) {
+		q.limits = limits
+	}
+}
+
+// WithQuotaPinned sets clients which counters are never dropped by the max_clients cap.
+// Their number must be bounded, e.g. by the config.
+func WithQuotaPinned(pinned func(key string) bool) func(*Quota) {
+	return func(q *Quota) {
+		q.pinned = pinned
+	}
+}
+
+// WithQuotaLogger sets logger of the quota, by default it is slog.Default()
+func WithQuotaLogger(logger *slog.Logger) func(*Quota) {
+	return func(q *Quota) {
+		q.logger = logger
+	}
+}
+
+// NewQuota creates quota and loads saved counters from the store, store can be nil
+func NewQuota(ctx context.Context, cfg config.QuotaConfig, store QuotaStore, options ...func(*Quota)) (*Quota, error) {
+	switch cfg.Period {
+	case "day", "month":
+	default:
+		return nil, fmt.Errorf("unknown quota period %q", cfg.Period)
+	}
+	location := time.UTC
+	if cfg.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota timezone: %w", err)
+		}
+	}
+
+	q := &Quota{
+		limit:         cfg.Limit,
+		period:        cfg.Period,
+		location:      location,
+		store:         store,
+		flushInterval: cfg.FlushIntervalMS.AsDuration(),
+		maxClients:    cfg.MaxClients,
+		now:           time.Now,
+		usage:         make(map[string]*quotaEntry),
+		lru:           list.New(),
+	}
+	if q.flushInterval <= 0 {
+		q.flushInterval = defaultQuotaFlushInterval
+	}
+	if q.maxClients <= 0 {
+		q.maxClients = defaultMaxClients
+	}
+	q.limits = func(string) int { return q.limit }
+	q.pinned = func(string) bool { return false }
+	for _, o := range options {
+		o(q)
+	}
+	q.logger = logging.Component(q.logger, "quota")
+
+	if store != nil {
+		usage, err := store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load quota counters: %w", err)
+		}
+		for key, u := range usage {
+			q.storeLocked(key, u)
+		}
+		// Start in separate goroutine periodical task with saving of counters
+		go q.flushRoutine(ctx)
+	}
+	return q, nil
+}
+
+// periodStart returns start of the period containing t
+func (q *Quota) periodStart(t time.Time) time.Time {
+	t = t.In(q.location)
+	if q.period == "month" {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, q.location)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, q.location)
+}
+
+func (q *Quota) nextPeriod(start time.Time) time.Time {
+	if q.period == "month" {
+		return start.AddDate(0, 1, 0)
+	}
+	// AddDate keeps wall clock, so days with DST transitions are 23 or 25 hours long
+	return start.AddDate(0, 0, 1)
+}
+
+// currentLocked returns usage of the key in the current period, q.mu must be held
+func (q *Quota) currentLocked(key string, start time.Time) QuotaUsage {
+	e, ok := q.usage[key]
+	if !ok || !e.usage.PeriodStart.Equal(start) {
+		return QuotaUsage{PeriodStart: start}
+	}
+	return e.usage
+}
+
+// storeLocked saves usage of the key and drops least recently used counters over the cap, q.mu must be held
+func (q *Quota) storeLocked(key string, u QuotaUsage) {
+	if e, ok := q.usage[key]; ok {
+		e.usage = u
+		q.touchLocked(e)
+		return
+	}
+	e := &quotaEntry{key: key, usage: u}
+	q.usage[key] = e
+	if q.pinned(key) {
+		return
+	}
+	e.elem = q.lru.PushFront(e)
+	for q.lru.Len() > q.maxClients {
+		q.removeLocked(q.lru.Back().Value.(*quotaEntry))
+	}
+}
+
+func (q *Quota) touchLocked(e *quotaEntry) {
+	if e.elem != nil {
+		q.lru.MoveToFront(e.elem)
+	}
+}
+
+func (q *Quota) removeLocked(e *quotaEntry) {
+	if e.elem != nil {
+		q.lru.Remove(e.elem)
+	}
+	delete(q.usage, e.key)
+}
+
+// Consume takes n requests of the client quota, nothing is taken if the quota is not enough
+func (q *Quota) Consume(key string, n int) QuotaDecision {
+	return q.decide(key, n)
+}
+
+// Usage returns quota of the client without taking anything
+func (q *Quota) Usage(key string) QuotaDecision {
+	return q.decide(key, 0)
+}
+
+func (q *Quota) decide(key string, n int) QuotaDecision {
+	start := q.periodStart(q.now())
+	limit := q.limits(key)
+	if limit <= 0 {
+		return QuotaDecision{Allowed: true, Unlimited: true, ResetAt: q.nextPeriod(start)}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e, ok := q.usage[key]; ok {
+		q.touchLocked(e)
+	}
+	u := q.currentLocked(key, start)
+	allowed := u.Used+n <= limit && (n > 0 || u.Used < limit)
+	if allowed && n > 0 {
+		u.Used += n
+		q.storeLocked(key, u)
+		q.dirty = true
+	}
+	return QuotaDecision{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: max(limit-u.Used, 0),
+		ResetAt:   q.nextPeriod(start),
+	}
+}
+
+// Reset renews quota of the client in the current period
+func (q *Quota) Reset(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e, ok := q.usage[key]; ok {
+		q.removeLocked(e)
+	}
+	q.dirty = true
+}
+
+// Len returns number of currently tracked client counters
+func (q *Quota) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.usage)
+}
+
+// Period returns quota period: day or month
+func (q *Quota) Period() string {
+	return q.period
+}
+
+func (q *Quota) flushRoutine(ctx context.Context) {
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Final save is done by Flush after the server stops accepting requests
+			return
+		case <-ticker.C:
+			q.Flush()
+		}
+	}
+}
+
+// Flush saves counters of the current period, counters of the past periods are dropped
+func (q *Quota) Flush() {
+	if q.store == nil {
+		return
+	}
+
+	start := q.periodStart(q.now())
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return
+	}
+	snapshot := make(map[string]QuotaUsage, len(q.usage))
+	for key, e := range q.usage {
+		if e.usage.PeriodStart.Equal(start) {
+			snapshot[key] = e.usage
+		} else {
+			q.removeLocked(e)
+		}
+	}
+	q.dirty = false
+	q.mu.Unlock()
+
+	if err := q.store.Save(snapshot); err != nil {
+		q.logger.Warn("Failed to save quota counters", logging.ErrorKey, err)
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+	}
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileQuotaStore keeps quota counters in JSON file
+type FileQuotaStore struct {
+	path string
+}
+
+func NewFileQuotaStore(path string) *FileQuotaStore {
+	return &FileQuotaStore{path: path}
+}
+
+// Load returns saved counters, missing file means there are no counters yet
+func (s *FileQuotaStore) Load() (map[string]QuotaUsage, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]QuotaUsage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var usage map[string]QuotaUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, fmt.Errorf("invalid quota file %s: %w", s.path, err)
+	}
+	return usage, nil
+}
+
+// Save replaces file atomically, so crash during the write does not lose previous counters
+func (s *FileQuotaStore) Save(usage map[string]QuotaUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
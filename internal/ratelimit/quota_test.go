@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func newTestQuota(t *testing.T, cfg config.QuotaConfig, store QuotaStore, now *time.Time) *Quota {
+	t.Helper()
+	q, err := NewQuota(t.Context(), cfg, store)
+	require.NoError(t, err)
+	q.now = func() time.Time { return *now }
+	return q
+}
+
+func TestQuota_DailyInTimezone(t *testing.T) {
+	t.Parallel()
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	// 23:30 in Moscow, but still 20:30 in UTC
+	now := time.Date(2024, 3, 10, 23, 30, 0, 0, moscow)
+	q := newTestQuota(t, config.QuotaConfig{Limit: 2, Period: "day", Timezone: "Europe/Moscow"}, nil, &now)
+
+	require.True(t, q.Consume("client", 1).Allowed)
+	decision := q.Consume("client", 1)
+	require.True(t, decision.Allowed)
+	require.Equal(t, 0, decision.Remaining)
+	require.True(t, decision.ResetAt.Equal(time.Date(2024, 3, 11, 0, 0, 0, 0, moscow)))
+	require.False(t, q.Consume("client", 1).Allowed)
+	require.True(t, q.Consume("other", 1).Allowed, "clients have separate quotas")
+
+	now = now.Add(time.Hour)
+	decision = q.Consume("client", 1)
+	require.True(t, decision.Allowed, "quota is renewed at local midnight")
+	require.Equal(t, 1, decision.Remaining)
+}
+
+func TestQuota_Monthly(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	q := newTestQuota(t, config.QuotaConfig{Limit: 10, Period: "month"}, nil, &now)
+
+	require.False(t, q.Consume("client", 11).Allowed, "request over the quota takes nothing")
+	decision := q.Consume("client", 10)
+	require.True(t, decision.Allowed)
+	require.True(t, decision.ResetAt.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)))
+
+	now = time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, 10, q.Usage("client").Remaining)
+}
+
+func TestQuota_Reset(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := newTestQuota(t, config.QuotaConfig{Limit: 1, Period: "day"}, nil, &now)
+
+	require.True(t, q.Consume("client", 1).Allowed)
+	require.False(t, q.Usage("client").Allowed)
+	q.Reset("client")
+	require.True(t, q.Consume("client", 1).Allowed)
+}
+
+func TestQuota_PersistsCounters(t *testing.T) {
+	t.Parallel()
+	store := NewFileQuotaStore(filepath.Join(t.TempDir(), "quota.json"))
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cfg := config.QuotaConfig{Limit: 5, Period: "day"}
+
+	q := newTestQuota(t, cfg, store, &now)
+	q.Consume("client", 3)
+	q.Consume("stale", 1)
+	q.Flush()
+
+	restarted := newTestQuota(t, cfg, store, &now)
+	require.Equal(t, 2, restarted.Usage("client").Remaining, "counters must survive restart")
+
+	// Counters of the past periods are not saved
+	now = now.AddDate(0, 0, 1)
+	restarted.Consume("client", 1)
+	restarted.Flush()
+	usage, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, map[string]QuotaUsage{
+		"client": {Used: 1, PeriodStart: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}, usage)
+}
+
+func TestQuota_MaxClients(t *testing.T) {
+	t.Parallel()
+	store := NewFileQuotaStore(filepath.Join(t.TempDir(), "quota.json"))
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := newTestQuota(t, config.QuotaConfig{Limit: 5, Period: "day", MaxClients: 100}, store, &now)
+
+	require.True(t, q.Consume("client", 5).Allowed)
+	for i := range 10_000 {
+		q.Consume("flood-"+strconv.Itoa(i), 1)
+		if i%50 == 0 {
+			// Keep the real client recently used
+			require.False(t, q.Consume("client", 1).Allowed)
+		}
+	}
+	require.Equal(t, 100, q.Len(), "number of counters is bounded")
+	require.False(t, q.Usage("client").Allowed, "recently used counter is kept")
+
+	q.Flush()
+	usage, err := store.Load()
+	require.NoError(t, err)
+	require.Len(t, usage, 100)
+}
+
+func TestQuota_PinnedSurvivesFlood(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	q, err := NewQuota(t.Context(), config.QuotaConfig{Limit: 5, Period: "day", MaxClients: 10}, nil,
+		WithQuotaPinned(func(key string) bool { return key == "paid" }))
+	require.NoError(t, err)
+	q.now = func() time.Time { return now }
+
+	require.True(t, q.Consume("paid", 5).Allowed)
+	require.True(t, q.Consume("anonymous", 5).Allowed)
+	for i := range 1000 {
+		q.Consume("flood-"+strconv.Itoa(i), 1)
+	}
+	require.Equal(t, 11, q.Len(), "pinned counter is kept over the cap")
+
+	require.False(t, q.Consume("paid", 1).Allowed, "flood does not renew quota of pinned client")
+	require.Equal(t, 0, q.Usage("paid").Remaining)
+	require.True(t, q.Consume("anonymous", 1).Allowed, "not pinned counter is dropped")
+}
+
+func TestNewQuota_InvalidConfig(t *testing.T) {
+	t.Parallel()
+	_, err := NewQuota(t.Context(), config.QuotaConfig{Limit: 1, Period: "week"}, nil)
+	require.Error(t, err)
+	_, err = NewQuota(t.Context(), config.QuotaConfig{Limit: 1, Period: "day", Timezone: "Mars/Olympus"}, nil)
+	require.Error(t, err)
+}
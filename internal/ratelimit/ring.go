@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is number of virtual nodes per peer, they smooth out key distribution
+const ringReplicas = 128
+
+// hashRing is consistent hash ring of peers. Hash must be the same in every process,
+// so that all replicas agree on key owners without any coordination.
+type hashRing struct {
+	points []uint64
+	owners map[uint64]string
+}
+
+func newHashRing(peers []string) *hashRing {
+	ring := &hashRing{
+		points: make([]uint64, 0, len(peers)*ringReplicas),
+		owners: make(map[uint64]string, len(peers)*ringReplicas),
+	}
+	for _, peer := range peers {
+		for i := range ringReplicas {
+			point := ringHash(peer + "#" + strconv.Itoa(i))
+			ring.points = append(ring.points, point)
+			ring.owners[point] = peer
+		}
+	}
+	slices.Sort(ring.points)
+	return ring
+}
+
+// Owner returns peer responsible for the key: the first point clockwise from the key hash
+func (ring *hashRing) Owner(key string) string {
+	if len(ring.points) == 0 {
+		return ""
+	}
+	hash := ringHash(key)
+	i := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= hash })
+	if i == len(ring.points) {
+		i = 0
+	}
+	return ring.owners[ring.points[i]]
+}
+
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	// FNV of similar strings (peer#1, peer#2, ...) is clustered, so spread it with murmur3 finalizer
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
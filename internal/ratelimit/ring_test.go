@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashRing_SpreadsKeys(t *testing.T) {
+	t.Parallel()
+	peers := []string{"127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003"}
+	ring := newHashRing(peers)
+	// Order of the list does not matter, all replicas must agree on owners
+	reversed := newHashRing([]string{peers[2], peers[1], peers[0]})
+
+	owned := map[string]int{}
+	const keys = 30_000
+	for i := range keys {
+		key := "client-" + strconv.Itoa(i)
+		owner := ring.Owner(key)
+		require.Equal(t, owner, reversed.Owner(key))
+		owned[owner]++
+	}
+
+	require.Len(t, owned, len(peers))
+	for peer, n := range owned {
+		require.InDelta(t, keys/len(peers), n, keys/10, "peer %s owns too few or too many keys", peer)
+	}
+}
+
+func TestHashRing_RemovedPeerMovesOnlyItsKeys(t *testing.T) {
+	t.Parallel()
+	before := newHashRing([]string{"a:1", "b:1", "c:1", "d:1"})
+	after := newHashRing([]string{"a:1", "b:1", "c:1"})
+
+	for i := range 10_000 {
+		key := "client-" + strconv.Itoa(i)
+		if owner := before.Owner(key); owner != "d:1" {
+			require.Equal(t, owner, after.Owner(key), "key %s of alive peer moved", key)
+		}
+	}
+}
+
+func TestHashRing_Empty(t *testing.T) {
+	t.Parallel()
+	require.Empty(t, newHashRing(nil).Owner("client"))
+}
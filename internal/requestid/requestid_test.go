@@ -0,0 +1,37 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	seen := make(map[string]struct{})
+	for range 1000 {
+		id := New()
+		require.Len(t, id, 32)
+		require.True(t, Valid(id))
+		_, dup := seen[id]
+		require.False(t, dup)
+		seen[id] = struct{}{}
+	}
+}
+
+func TestValid(t *testing.T) {
+	t.Parallel()
+	require.True(t, Valid("9f1c-42:abc.def_1"))
+	require.False(t, Valid(""))
+	require.False(t, Valid("with space"))
+	require.False(t, Valid("line\nbreak"))
+	require.False(t, Valid(strings.Repeat("a", maxLength+1)))
+}
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+	require.Empty(t, FromContext(context.Background()))
+	require.Equal(t, "abc", FromContext(NewContext(context.Background(), "abc")))
+}
@@ -0,0 +1,277 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout  = time.Second
+	defaultPoolSize = 16
+)
+
+// Error is error reply of the server, e.g. "NOSCRIPT No matching script"
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Client is minimal client of Redis serialization protocol (RESP2).
+// It is safe for concurrent use, connections are reused through the pool.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	pool     chan *conn
+
+	// mu guards closed, so connection is not returned to the pool while it is drained
+	mu     sync.Mutex
+	closed bool
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func WithPassword(password string) func(*Client) {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+func WithDB(db int) func(*Client) {
+	return func(c *Client) {
+		c.db = db
+	}
+}
+
+// WithTimeout sets dial and single command timeout
+func WithTimeout(timeout time.Duration) func(*Client) {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+func WithPoolSize(size int) func(*Client) {
+	return func(c *Client) {
+		if size > 0 {
+			c.pool = make(chan *conn, size)
+		}
+	}
+}
+
+func NewClient(addr string, options ...func(*Client)) *Client {
+	c := &Client{
+		addr:    addr,
+		timeout: defaultTimeout,
+		pool:    make(chan *conn, defaultPoolSize),
+	}
+	for _, o := range options {
+		o(c)
+	}
+	return c
+}
+
+// Do sends command and returns reply: string, int64, nil, []any or Error
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.roundTrip(ctx, cn, args)
+	if err != nil {
+		// Connection state is unknown after network error
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+func (c *Client) roundTrip(ctx context.Context, cn *conn, args []string) (any, error) {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := writeCommand(cn.w, args); err != nil {
+		return nil, fmt.Errorf("failed to send command: %w", err)
+	}
+	reply, err := ReadValue(cn.r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reply: %w", err)
+	}
+	return reply, nil
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", c.addr, err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	// Prepare connection once, it is reused later with the same state
+	if c.password != "" {
+		if err := c.prepare(ctx, cn, "AUTH", c.password); err != nil {
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if err := c.prepare(ctx, cn, "SELECT", strconv.Itoa(c.db)); err != nil {
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) prepare(ctx context.Context, cn *conn, args ...string) error {
+	reply, err := c.roundTrip(ctx, cn, args)
+	if err == nil {
+		if e, ok := reply.(Error); ok {
+			err = e
+		}
+	}
+	if err != nil {
+		cn.Close()
+		return fmt.Errorf("%s failed: %w", args[0], err)
+	}
+	return nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cn.Close()
+		return
+	}
+	select {
+	case c.pool <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// Close closes idle connections and stops pooling: connections in use are closed when returned.
+// Client can still be used after Close (e.g. to flush state on shutdown), every command then uses
+// its own connection.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for {
+		select {
+		case cn := <-c.pool:
+			cn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func writeCommand(w *bufio.Writer, args []string) error {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		w.WriteString(arg)
+		w.WriteString("\r\n")
+	}
+	return w.Flush()
+}
+
+var errProtocol = errors.New("resp: protocol error")
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// ReadValue reads single RESP value: string, int64, nil, []any or Error
+func ReadValue(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return n, nil
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errProtocol
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errProtocol
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		items := make([]any, 0, size)
+		for range size {
+			item, err := ReadValue(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, errProtocol
+	}
+}
@@ -0,0 +1,97 @@
+package resp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Do(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	c := NewClient(store.Addr())
+	t.Cleanup(c.Close)
+
+	reply, err := c.Do(t.Context(), "SET", "key", "value")
+	require.NoError(t, err)
+	require.Equal(t, "OK", reply)
+	reply, err = c.Do(t.Context(), "GET", "key")
+	require.NoError(t, err)
+	require.Equal(t, "value", reply)
+	reply, err = c.Do(t.Context(), "GET", "missing")
+	require.NoError(t, err)
+	require.Nil(t, reply)
+	reply, err = c.Do(t.Context(), "INCRBY", "counter", "5")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), reply)
+	reply, err = c.Do(t.Context(), "MGET", "key", "missing")
+	require.NoError(t, err)
+	require.Equal(t, []any{"value", nil}, reply)
+
+	_, err = c.Do(t.Context(), "INCR", "key")
+	var respErr Error
+	require.ErrorAs(t, err, &respErr, "error reply of the server")
+	reply, err = c.Do(t.Context(), "GET", "key")
+	require.NoError(t, err, "connection is usable after error reply")
+	require.Equal(t, "value", reply)
+}
+
+func TestClient_AuthAndDB(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	store.RequireAuth("secret")
+
+	_, err := NewClient(store.Addr(), WithPassword("wrong")).Do(t.Context(), "PING")
+	require.Error(t, err)
+
+	c := NewClient(store.Addr(), WithPassword("secret"), WithDB(2))
+	t.Cleanup(c.Close)
+	_, err = c.Do(t.Context(), "SET", "key", "value")
+	require.NoError(t, err)
+	store.Select(2)
+	value, err := store.Get("key")
+	require.NoError(t, err)
+	require.Equal(t, "value", value)
+}
+
+func TestClient_Close(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	c := NewClient(store.Addr(), WithTimeout(time.Second))
+
+	_, err := c.Do(t.Context(), "PING")
+	require.NoError(t, err)
+	require.Equal(t, 1, store.CurrentConnectionCount(), "connection is kept in the pool")
+
+	c.Close()
+	require.Eventually(t, func() bool { return store.CurrentConnectionCount() == 0 }, time.Second, 10*time.Millisecond)
+
+	// Client still works after Close, but connections are not pooled anymore
+	_, err = c.Do(t.Context(), "PING")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return store.CurrentConnectionCount() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestScript_Run(t *testing.T) {
+	t.Parallel()
+	store := miniredis.RunT(t)
+	c := NewClient(store.Addr())
+	t.Cleanup(c.Close)
+	script := NewScript(`return redis.call("INCRBY", KEYS[1], ARGV[1])`)
+
+	// Server does not know the script yet, so it is sent with EVAL
+	reply, err := script.Run(t.Context(), c, []string{"counter"}, "2")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), reply)
+	reply, err = script.Run(t.Context(), c, []string{"counter"}, "3")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), reply)
+
+	_, err = c.Do(t.Context(), "SCRIPT", "FLUSH")
+	require.NoError(t, err)
+	reply, err = script.Run(t.Context(), c, []string{"counter"}, "1")
+	require.NoError(t, err, "script is sent again after flush")
+	require.Equal(t, int64(6), reply)
+}
@@ -0,0 +1,49 @@
+package resp
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Script is Lua script executed atomically by the server.
+// It is sent by hash and the source is sent only if server does not know it yet.
+type Script struct {
+	src  string
+	hash string
+}
+
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{
+		src:  src,
+		hash: hex.EncodeToString(sum[:]),
+	}
+}
+
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+func (s *Script) Source() string {
+	return s.src
+}
+
+func (s *Script) Run(ctx context.Context, c *Client, keys []string, args ...string) (any, error) {
+	reply, err := c.Do(ctx, s.command("EVALSHA", s.hash, keys, args)...)
+	var respErr Error
+	if errors.As(err, &respErr) && strings.HasPrefix(string(respErr), "NOSCRIPT") {
+		return c.Do(ctx, s.command("EVAL", s.src, keys, args)...)
+	}
+	return reply, err
+}
+
+func (s *Script) command(name, script string, keys, args []string) []string {
+	cmd := make([]string, 0, 3+len(keys)+len(args))
+	cmd = append(cmd, name, script, strconv.Itoa(len(keys)))
+	cmd = append(cmd, keys...)
+	return append(cmd, args...)
+}
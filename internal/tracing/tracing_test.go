@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+)
+
+func TestNew_FileExporter(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "spans.json")
+	tp, err := New(t.Context(), config.TracingConfig{Exporter: "file", Path: path, ServiceName: "lb-test"})
+	require.NoError(t, err)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "request")
+	span.End()
+	require.NoError(t, tp.Shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"Name":"request"`)
+	require.Contains(t, string(data), "lb-test")
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	t.Parallel()
+	_, err := New(t.Context(), config.TracingConfig{Exporter: "zipkin"})
+	require.Error(t, err)
+	_, err = New(t.Context(), config.TracingConfig{Exporter: "file"})
+	require.Error(t, err)
+	ratio := 1.5
+	_, err = New(t.Context(), config.TracingConfig{Exporter: "stdout", SampleRatio: &ratio})
+	require.Error(t, err)
+}
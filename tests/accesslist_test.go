@@ -0,0 +1,80 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+func TestAccessList(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+
+	accessList, err := httpGateway.NewAccessList(config.AccessListConfig{AccessLists: config.AccessLists{
+		Allow: config.AccessListEntries{Keys: []string{"header:X-API-Key:monitoring"}},
+	}})
+	require.NoError(t, err)
+	rl, err := ratelimit.New(context.Background(), "token_bucket", config.TokenBucketLimiterOptions{
+		DefaultCapacity:         1,
+		DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	})
+	require.NoError(t, err)
+	srv := httpGateway.NewServer(t.Context(), lb, rl, httpGateway.WithAccessList(accessList))
+	apiServer := httptest.NewServer(srv.Handler())
+	defer apiServer.Close()
+	adminServer := httptest.NewServer(httpGateway.NewAdminServer(httpGateway.WithAccessListAdmin(accessList)).Handler())
+	defer adminServer.Close()
+
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	do := func(key string) int {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+		req.Header.Set("X-API-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	admin := func(method, path string) int {
+		req, _ := http.NewRequest(method, adminServer.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for range 5 {
+		require.Equal(t, http.StatusOK, do("monitoring"), "allowed client bypasses rate limit")
+	}
+	require.Equal(t, http.StatusOK, do("client"))
+	require.Equal(t, http.StatusTooManyRequests, do("client"))
+
+	require.Equal(t, http.StatusOK, admin(http.MethodPut, "/access-list/deny/keys/header:X-API-Key:abuser"))
+	require.Equal(t, http.StatusForbidden, do("abuser"))
+	require.Equal(t, http.StatusOK, admin(http.MethodPut, "/access-list/deny/cidrs/127.0.0.0/8"))
+	require.Equal(t, http.StatusForbidden, do("monitoring"), "deny wins over allow")
+
+	require.Equal(t, http.StatusOK, admin(http.MethodDelete, "/access-list/deny/cidrs/127.0.0.0/8"))
+	require.Equal(t, http.StatusOK, do("monitoring"))
+	require.Equal(t, http.StatusBadRequest, admin(http.MethodPut, "/access-list/grey/keys/abuser"))
+	require.Equal(t, http.StatusBadRequest, admin(http.MethodPut, "/access-list/deny/cidrs/not-a-cidr"))
+}
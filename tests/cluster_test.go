@@ -0,0 +1,135 @@
+package integration_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+// startReplicas starts limiters of n balancer replicas sharing limits through peer listeners on localhost
+func startReplicas(t *testing.T, n int, mode string) ([]*ratelimit.RateLimiter, []*http.Server) {
+	t.Helper()
+
+	listeners := make([]net.Listener, n)
+	addresses := make([]string, n)
+	for i := range n {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listeners[i] = l
+		addresses[i] = l.Addr().String()
+	}
+
+	limiters := make([]*ratelimit.RateLimiter, n)
+	servers := make([]*http.Server, n)
+	for i := range n {
+		cluster, err := ratelimit.NewCluster(t.Context(), config.PeersConfig{
+			Self:           addresses[i],
+			Addresses:      addresses,
+			Secret:         "secret",
+			Mode:           mode,
+			SyncIntervalMS: config.DurationMs(20),
+			TimeoutMS:      config.DurationMs(time.Second.Milliseconds()),
+		})
+		require.NoError(t, err)
+
+		limiters[i], err = ratelimit.New(t.Context(), "token_bucket", config.TokenBucketLimiterOptions{
+			DefaultCapacity:         10,
+			DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+		}, ratelimit.WithCluster(cluster, "global"))
+		require.NoError(t, err)
+
+		servers[i] = &http.Server{Handler: cluster.Handler()}
+		go servers[i].Serve(listeners[i])
+		t.Cleanup(func() { servers[i].Close() })
+	}
+	return limiters, servers
+}
+
+func allowedCount(t *testing.T, limiters []*ratelimit.RateLimiter, key string, rounds int) int {
+	t.Helper()
+	allowed := 0
+	for range rounds {
+		for _, rl := range limiters {
+			decision, err := rl.AllowRequest(context.Background(), key)
+			require.NoError(t, err)
+			if decision.Allowed {
+				allowed++
+			}
+		}
+	}
+	return allowed
+}
+
+func TestCluster_ForwardMode(t *testing.T) {
+	limiters, _ := startReplicas(t, 3, "forward")
+
+	for i := range 5 {
+		key := "client-" + strconv.Itoa(i)
+		require.Equal(t, 10, allowedCount(t, limiters, key, 10), "limit of %s must be global", key)
+	}
+}
+
+func TestCluster_BatchMode(t *testing.T) {
+	limiters, _ := startReplicas(t, 3, "batch")
+
+	// Replicas may overshoot within one sync interval, but the limit stays approximately global
+	allowed := allowedCount(t, limiters, "client", 10)
+	require.GreaterOrEqual(t, allowed, 10)
+	require.Less(t, allowed, 30)
+
+	require.Eventually(t, func() bool {
+		return allowedCount(t, limiters, "client", 1) == 0
+	}, time.Second, 30*time.Millisecond, "all replicas must learn that the limit is exhausted")
+}
+
+func TestCluster_OwnerUnavailable(t *testing.T) {
+	limiters, servers := startReplicas(t, 2, "forward")
+	for _, srv := range servers {
+		require.NoError(t, srv.Close())
+	}
+
+	// Every replica falls back to its own local limit
+	require.Equal(t, 20, allowedCount(t, limiters, "client", 15))
+}
+
+func TestCluster_PeerAuth(t *testing.T) {
+	_, err := ratelimit.NewCluster(t.Context(), config.PeersConfig{Self: "a:1", Addresses: []string{"a:1"}})
+	require.Error(t, err, "secret is required")
+
+	cluster, err := ratelimit.NewCluster(t.Context(), config.PeersConfig{
+		Self:      "a:1",
+		Addresses: []string{"a:1"},
+		Secret:    "secret",
+	})
+	require.NoError(t, err)
+	_, err = ratelimit.New(t.Context(), "token_bucket", config.TokenBucketLimiterOptions{
+		DefaultCapacity:         10,
+		DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	}, ratelimit.WithCluster(cluster, "global"))
+	require.NoError(t, err)
+
+	do := func(secret, body string) int {
+		r := httptest.NewRequest(http.MethodPost, ratelimit.PeerHitsPath, strings.NewReader(body))
+		if secret != "" {
+			r.Header.Set(ratelimit.PeerSecretHeader, secret)
+		}
+		w := httptest.NewRecorder()
+		cluster.Handler().ServeHTTP(w, r)
+		return w.Code
+	}
+
+	hits := `[{"limiter": "global", "key": "client", "hits": 1}]`
+	require.Equal(t, http.StatusUnauthorized, do("", hits))
+	require.Equal(t, http.StatusUnauthorized, do("wrong", hits))
+	require.Equal(t, http.StatusOK, do("secret", hits))
+	require.Equal(t, http.StatusBadRequest, do("secret", `[{"limiter": "global", "key": "client", "hits": -100}]`))
+}
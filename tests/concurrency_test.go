@@ -0,0 +1,83 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	// Backend holds long-polling requests until they are released or cancelled
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/poll" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+
+	limiter := ratelimit.NewConcurrencyLimiter(2)
+	srv := httpGateway.NewServer(t.Context(), lb, nil, httpGateway.WithConcurrencyLimiter(limiter))
+	apiServer := httptest.NewServer(srv.Handler())
+	defer apiServer.Close()
+
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	do := func(ctx context.Context, key, path string) (int, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, apiServer.URL+path, nil)
+		req.Header.Set("X-API-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	// Limiter is keyed by client key, i.e. namespaced API key
+	const greedyKey = "header:X-API-Key:greedy"
+	pollCtx, cancelPoll := context.WithCancel(t.Context())
+	for range 2 {
+		go func() { _, _ = do(pollCtx, "greedy", "/poll") }()
+	}
+	require.Eventually(t, func() bool { return limiter.InFlight(greedyKey) == 2 }, time.Second, 10*time.Millisecond)
+
+	code, err := do(t.Context(), "greedy", "/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTooManyRequests, code, "client over its in-flight limit must be rejected")
+
+	code, err = do(t.Context(), "polite", "/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, code, "other clients must not be starved")
+
+	// Client cancellation releases slots
+	cancelPoll()
+	require.Eventually(t, func() bool { return limiter.InFlight(greedyKey) == 0 }, time.Second, 10*time.Millisecond)
+
+	code, err = do(t.Context(), "greedy", "/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, code)
+}
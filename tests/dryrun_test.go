@@ -0,0 +1,83 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+func TestRateLimitDryRun(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+
+	newLimiter := func(capacity int) *ratelimit.RateLimiter {
+		rl, err := ratelimit.New(context.Background(), "token_bucket", config.TokenBucketLimiterOptions{
+			DefaultCapacity:         capacity,
+			DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+		})
+		require.NoError(t, err)
+		return rl
+	}
+	matchAll, err := httpGateway.NewRequestMatcher(config.MatchConfig{})
+	require.NoError(t, err)
+
+	stats := httpGateway.NewDryRunStats()
+	srv := httpGateway.NewServer(
+		t.Context(),
+		lb,
+		newLimiter(1),
+		httpGateway.WithRateLimitDryRun(true),
+		httpGateway.WithDryRunStats(stats),
+		httpGateway.WithRateLimitPolicies(httpGateway.RateLimitPolicy{
+			Name:    "enforced",
+			Match:   matchAll,
+			Limiter: newLimiter(3),
+			Keys:    httpGateway.DefaultKeyExtractor(),
+		}),
+	)
+	apiServer := httptest.NewServer(srv.Handler())
+	defer apiServer.Close()
+
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	do := func() *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+		req.Header.Set("X-API-Key", "client")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	for i := range 3 {
+		resp := do()
+		require.Equal(t, http.StatusOK, resp.StatusCode, "dry-run limit must not reject")
+		require.Equal(t, "3", resp.Header.Get("RateLimit-Limit"), "only enforced limits are announced")
+		require.Equal(t, strconv.Itoa(2-i), resp.Header.Get("RateLimit-Remaining"))
+	}
+	require.Equal(t, http.StatusTooManyRequests, do().StatusCode, "enforced policy still rejects")
+
+	require.Equal(t, map[httpGateway.DryRunKey]uint64{
+		{Policy: httpGateway.GlobalPolicyName, Client: "header:X-API-Key:client"}: 3,
+	}, stats.Snapshot())
+}
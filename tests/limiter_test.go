@@ -67,10 +67,12 @@ func (s *RateLimiterSuite) SetupSuite() {
 		},
 	)
 
-	s.rl = ratelimit.New("token_bucket", config.TokenBucketLimiterOptions{
+	var err error
+	s.rl, err = ratelimit.New(context.Background(), "token_bucket", config.TokenBucketLimiterOptions{
 		DefaultCapacity:         3,
 		DefaultRefillIntervalMS: 200,
 	})
+	s.Require().NoError(err)
 
 	srvImpl := httpGateway.NewServer(
 		context.Background(),
@@ -130,3 +132,31 @@ func (s *RateLimiterSuite) TestTokenBucket_LimitAndRefill() {
 	code, _ = s.doRequest(key)
 	s.Equal(http.StatusTooManyRequests, code, "next request after using refill should be limited")
 }
+
+func (s *RateLimiterSuite) TestTokenBucket_RateLimitHeaders() {
+	s.waitAlive(1)
+
+	do := func() *http.Response {
+		req, _ := http.NewRequest("GET", s.apiServer.URL+"/", nil)
+		req.Header.Set("X-API-Key", "headers-client")
+		resp, err := http.DefaultClient.Do(req)
+		s.Require().NoError(err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("3", resp.Header.Get("RateLimit-Limit"))
+	s.Equal("2", resp.Header.Get("RateLimit-Remaining"))
+	s.Equal("1", resp.Header.Get("RateLimit-Reset"))
+	s.Empty(resp.Header.Get("Retry-After"))
+
+	do()
+	do()
+	resp = do()
+	s.Equal(http.StatusTooManyRequests, resp.StatusCode)
+	s.Equal("0", resp.Header.Get("RateLimit-Remaining"))
+	s.Equal("1", resp.Header.Get("Retry-After"))
+}
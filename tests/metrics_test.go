@@ -0,0 +1,104 @@
+package integration_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/metrics"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+func TestMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	m := metrics.New()
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+		m.HealthCheckObserver("default"),
+	)
+	m.WatchUpstream("default", lb)
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	newLimiter := func(capacity int) *ratelimit.RateLimiter {
+		rl, err := ratelimit.New(context.Background(), "token_bucket", config.TokenBucketLimiterOptions{
+			DefaultCapacity:         capacity,
+			DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+		})
+		require.NoError(t, err)
+		return rl
+	}
+	rl := newLimiter(2)
+	m.WatchLimiter(httpGateway.GlobalPolicyName, rl)
+	matchAll, err := httpGateway.NewRequestMatcher(config.MatchConfig{})
+	require.NoError(t, err)
+
+	srv := httpGateway.NewServer(
+		t.Context(),
+		lb,
+		rl,
+		httpGateway.WithMetrics(m),
+		httpGateway.WithRateLimitPolicies(httpGateway.RateLimitPolicy{
+			Name:    "shadow",
+			Match:   matchAll,
+			Limiter: newLimiter(1),
+			Keys:    httpGateway.DefaultKeyExtractor(),
+			DryRun:  true,
+		}),
+	)
+	apiServer := httptest.NewServer(srv.Handler())
+	defer apiServer.Close()
+	admin := httptest.NewServer(httpGateway.NewAdminServer(httpGateway.WithMetricsAdmin(m)).Handler())
+	defer admin.Close()
+
+	for range 3 {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+		req.Header.Set("X-API-Key", "client")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(admin.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	text := string(body)
+
+	for _, line := range []string{
+		`lb_requests_total{backend="` + backend.URL + `",route="",status="200"} 2`,
+		`lb_requests_total{backend="",route="",status="429"} 1`,
+		`lb_request_duration_seconds_count{backend="` + backend.URL + `",route="",status="200"} 2`,
+		`lb_ratelimit_requests_total{policy="global",result="allowed"} 2`,
+		`lb_ratelimit_requests_total{policy="global",result="rejected"} 1`,
+		`lb_ratelimit_requests_total{policy="shadow",result="allowed"} 1`,
+		// Third request is rejected by the global policy before the shadow one is checked
+		`lb_ratelimit_requests_total{policy="shadow",result="dry_run_rejected"} 1`,
+		`lb_ratelimit_clients{policy="global"} 1`,
+		`lb_backend_up{backend="` + backend.URL + `",upstream="default"} 1`,
+		`lb_backend_active_connections{backend="` + backend.URL + `",upstream="default"} 0`,
+	} {
+		require.True(t, strings.Contains(text, line), "metrics have no line %s", line)
+	}
+	require.NotContains(t, text, "client=", "clients are not metric labels, they are in GET /ratelimit/dry-run")
+	healthChecks := `lb_backend_health_check_duration_seconds_count{backend="` + backend.URL + `",upstream="default"}`
+	require.True(t, strings.Contains(text, healthChecks), "health checks are observed")
+}
@@ -0,0 +1,176 @@
+package integration_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+)
+
+// recordingBackend stores received requests
+type recordingBackend struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (b *recordingBackend) received() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.bodies...)
+}
+
+func newRecordingPool(t *testing.T, name string, delay time.Duration) (*balancer.LoadBalancer, *recordingBackend) {
+	t.Helper()
+	rec := &recordingBackend{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/ping" {
+			return
+		}
+		rec.mu.Lock()
+		rec.bodies = append(rec.bodies, r.Method+" "+r.URL.Path+" "+string(body))
+		rec.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+		_, _ = w.Write([]byte(name))
+	}))
+	t.Cleanup(backend.Close)
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+	return lb, rec
+}
+
+func newMirrorServer(t *testing.T, mirror config.MirrorConfig, shadowDelay time.Duration) (*httptest.Server, *recordingBackend, *recordingBackend) {
+	t.Helper()
+	primary, primaryRec := newRecordingPool(t, "primary", 0)
+	shadow, shadowRec := newRecordingPool(t, "shadow", shadowDelay)
+	mirror.Upstream = "shadow"
+	routes, err := httpGateway.NewRoutes([]config.RouteConfig{
+		{Name: "api", Upstream: "primary", Mirror: &mirror},
+	}, map[string]*balancer.LoadBalancer{"primary": primary, "shadow": shadow}, httpGateway.DefaultKeyExtractor())
+	require.NoError(t, err)
+	return newRoutingServer(t, nil, routes), primaryRec, shadowRec
+}
+
+func post(t *testing.T, target, body string) string {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	code, resp := routedTo(t, req)
+	require.Equal(t, http.StatusOK, code)
+	return resp
+}
+
+func TestMirror(t *testing.T) {
+	apiServer, primary, shadow := newMirrorServer(t, config.MirrorConfig{Percent: 100}, 0)
+
+	require.Equal(t, "primary", post(t, apiServer.URL+"/orders", `{"id":1}`), "response comes from the primary pool")
+	require.Equal(t, []string{`POST /orders {"id":1}`}, primary.received())
+	require.Eventually(t, func() bool {
+		return slices.Equal([]string{`POST /orders {"id":1}`}, shadow.received())
+	}, time.Second, 10*time.Millisecond, "shadow pool gets the same request")
+}
+
+func TestMirror_SlowShadow(t *testing.T) {
+	apiServer, primary, shadow := newMirrorServer(t, config.MirrorConfig{
+		Percent:     100,
+		TimeoutMS:   config.DurationMs(time.Second.Milliseconds()),
+		MaxInFlight: 1,
+	}, time.Hour)
+
+	start := time.Now()
+	for range 5 {
+		require.Equal(t, "primary", post(t, apiServer.URL+"/orders", "body"))
+	}
+	require.Less(t, time.Since(start), 500*time.Millisecond, "slow shadow must not delay primary requests")
+	require.Len(t, primary.received(), 5)
+	require.Len(t, shadow.received(), 1, "copies over the in-flight cap are skipped")
+}
+
+func TestMirror_LargeBody(t *testing.T) {
+	apiServer, primary, shadow := newMirrorServer(t, config.MirrorConfig{Percent: 100, MaxBodyBytes: 4}, 0)
+
+	require.Equal(t, "primary", post(t, apiServer.URL+"/upload", "large body"))
+	require.Equal(t, []string{"POST /upload large body"}, primary.received(), "primary gets the whole body")
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, shadow.received())
+}
+
+func TestMirror_StreamsBody(t *testing.T) {
+	firstChunk := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			return
+		}
+		buf := make([]byte, len("first"))
+		_, _ = io.ReadFull(r.Body, buf)
+		firstChunk <- string(buf)
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write([]byte("primary"))
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	primary := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+	require.Eventually(t, func() bool { return primary.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+	shadow, shadowRec := newRecordingPool(t, "shadow", 0)
+	routes, err := httpGateway.NewRoutes([]config.RouteConfig{
+		{Name: "api", Upstream: "primary", Mirror: &config.MirrorConfig{Upstream: "shadow", Percent: 100}},
+	}, map[string]*balancer.LoadBalancer{"primary": primary, "shadow": shadow}, httpGateway.DefaultKeyExtractor())
+	require.NoError(t, err)
+	apiServer := newRoutingServer(t, nil, routes)
+
+	// Body is uploaded slowly, primary backend must get its beginning before the upload ends
+	bodyReader, bodyWriter := io.Pipe()
+	defer bodyWriter.Close()
+	req, _ := http.NewRequest(http.MethodPost, apiServer.URL+"/upload", bodyReader)
+	req.Header.Set("X-API-Key", "client")
+	done := make(chan int, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	_, err = bodyWriter.Write([]byte("first"))
+	require.NoError(t, err)
+	select {
+	case chunk := <-firstChunk:
+		require.Equal(t, "first", chunk)
+	case <-time.After(time.Second):
+		t.Fatal("body is not streamed to the primary backend")
+	}
+	_, err = bodyWriter.Write([]byte(" second"))
+	require.NoError(t, err)
+	require.NoError(t, bodyWriter.Close())
+	require.Equal(t, http.StatusOK, <-done)
+
+	require.Eventually(t, func() bool {
+		return slices.Equal([]string{"POST /upload first second"}, shadowRec.received())
+	}, time.Second, 10*time.Millisecond, "shadow pool gets the whole body")
+}
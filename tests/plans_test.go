@@ -0,0 +1,44 @@
+package integration_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+func TestPlansAdmin(t *testing.T) {
+	plans, err := ratelimit.NewPlans(config.PlansConfig{
+		Definitions: map[string]config.PlanLimits{
+			"free": {Rate: 1, PeriodMS: config.DurationMs(time.Hour.Milliseconds()), Burst: 1, QuotaLimit: 10},
+		},
+		Default: "free",
+	})
+	require.NoError(t, err)
+	adminServer := httptest.NewServer(httpGateway.NewAdminServer(httpGateway.WithPlansAdmin(plans)).Handler())
+	defer adminServer.Close()
+
+	put := func(name, body string) int {
+		req, _ := http.NewRequest(http.MethodPut, adminServer.URL+"/plans/"+name, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusNotFound, put("gold", `{"rate": 100, "period_ms": 1000}`), "plans are not created at runtime")
+	require.Equal(t, http.StatusBadRequest, put("free", `{"rate": 1, "burst": -5}`))
+	require.Equal(t, http.StatusBadRequest, put("free", `{"rate": "fast"}`))
+	require.Equal(t, http.StatusBadRequest, put("free", `{"rate": 100, "period_ms": 0}`), "rate without period is not a limit")
+	require.Equal(t, 10, plans.QuotaLimit("client"), "rejected changes are not applied")
+
+	require.Equal(t, http.StatusOK, put("free", `{"rate": 5, "period_ms": 1000, "burst": 5}`))
+	require.Equal(t, 5, plans.Limits("client").Burst)
+	require.Zero(t, plans.QuotaLimit("client"), "missing quota_limit means no quota")
+}
@@ -0,0 +1,91 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+func TestRateLimitPolicies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+
+	newLimiter := func(capacity int) *ratelimit.RateLimiter {
+		rl, err := ratelimit.New(context.Background(), "token_bucket", config.TokenBucketLimiterOptions{
+			DefaultCapacity:         capacity,
+			DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+		})
+		require.NoError(t, err)
+		return rl
+	}
+	newMatcher := func(cfg config.MatchConfig) httpGateway.RequestMatcher {
+		m, err := httpGateway.NewRequestMatcher(cfg)
+		require.NoError(t, err)
+		return m
+	}
+	keys := httpGateway.DefaultKeyExtractor()
+
+	srv := httpGateway.NewServer(
+		t.Context(),
+		lb,
+		newLimiter(5),
+		httpGateway.WithRateLimitPolicies(
+			httpGateway.RateLimitPolicy{
+				Name:    "search",
+				Match:   newMatcher(config.MatchConfig{PathPrefix: "/search"}),
+				Limiter: newLimiter(1),
+				Keys:    keys,
+			},
+			httpGateway.RateLimitPolicy{
+				Name:    "upload",
+				Match:   newMatcher(config.MatchConfig{PathRegex: "^/upload$", Methods: []string{"post"}}),
+				Limiter: newLimiter(1),
+				Keys:    keys,
+			},
+		),
+	)
+	apiServer := httptest.NewServer(srv.Handler())
+	defer apiServer.Close()
+
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	do := func(method, path string) int {
+		req, _ := http.NewRequest(method, apiServer.URL+path, nil)
+		req.Header.Set("X-API-Key", "client")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/search?q=1"))
+	require.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/search?q=2"), "search policy is exhausted")
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/upload"))
+	require.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/upload"), "upload policy is exhausted")
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/upload"), "GET does not match upload policy")
+
+	// Global policy counted only allowed requests above, rejected ones are refunded
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/health"))
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/health"))
+	require.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/health"), "global policy is exhausted")
+}
@@ -0,0 +1,92 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+func TestQuota(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+
+	quota, err := ratelimit.NewQuota(t.Context(), config.QuotaConfig{Limit: 2, Period: "day"}, nil)
+	require.NoError(t, err)
+	srv := httpGateway.NewServer(t.Context(), lb, nil, httpGateway.WithQuota(quota))
+	apiServer := httptest.NewServer(srv.Handler())
+	defer apiServer.Close()
+	adminServer := httptest.NewServer(httpGateway.NewAdminServer(httpGateway.WithQuotaAdmin(quota)).Handler())
+	defer adminServer.Close()
+
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	do := func() (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+		req.Header.Set("X-API-Key", "client")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, _ := do()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("X-Quota-Remaining"))
+	resp, _ = do()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "0", resp.Header.Get("X-Quota-Remaining"))
+
+	resp, body := do()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "0", resp.Header.Get("X-Quota-Remaining"))
+	require.NotEmpty(t, resp.Header.Get("Retry-After"))
+	var quotaError struct {
+		Message string `json:"message"`
+		Quota   struct {
+			Limit  int    `json:"limit"`
+			Period string `json:"period"`
+		} `json:"quota"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &quotaError))
+	require.Equal(t, "Quota exceeded", quotaError.Message)
+	require.Equal(t, 2, quotaError.Quota.Limit)
+	require.Equal(t, "day", quotaError.Quota.Period)
+
+	adminDo := func(method string) map[string]any {
+		req, _ := http.NewRequest(method, adminServer.URL+"/quotas/header:X-API-Key:client", nil)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var usage map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+		return usage
+	}
+	require.EqualValues(t, 2, adminDo(http.MethodGet)["used"])
+	require.EqualValues(t, 0, adminDo(http.MethodDelete)["used"], "quota must be reset through admin API")
+
+	resp, _ = do()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
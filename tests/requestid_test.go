@@ -0,0 +1,102 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/logging"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+// syncBuffer is written by handlers of the server and read by the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestID(t *testing.T) {
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("X-Request-ID")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	var logs syncBuffer
+	logger, err := logging.New(config.LogConfig{}, &logs)
+	require.NoError(t, err)
+	newServer := func(trusted config.CIDRList) *httptest.Server {
+		rl, err := ratelimit.New(context.Background(), "token_bucket", config.TokenBucketLimiterOptions{
+			DefaultCapacity:         1,
+			DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+		})
+		require.NoError(t, err)
+		srv := httpGateway.NewServer(
+			t.Context(), lb, rl, httpGateway.WithTrustedProxies(trusted), httpGateway.WithLogger(logger),
+		)
+		apiServer := httptest.NewServer(srv.Handler())
+		t.Cleanup(apiServer.Close)
+		return apiServer
+	}
+	do := func(apiServer *httptest.Server, id string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+		req.Header.Set("X-API-Key", "client")
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// Untrusted client cannot set ID
+	untrusted := newServer(nil)
+	resp := do(untrusted, "spoofed")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	id := resp.Header.Get("X-Request-ID")
+	require.Len(t, id, 32)
+	require.Equal(t, id, received, "backend gets the same ID")
+
+	// Rejected request is logged with its ID
+	resp = do(untrusted, "")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Contains(t, logs.String(), `msg="Request is rejected by rate limit"`)
+	require.Contains(t, logs.String(), "request_id="+resp.Header.Get("X-Request-ID"))
+
+	// ID from trusted proxy is kept
+	resp = do(newServer(config.CIDRList{netip.MustParsePrefix("127.0.0.0/8")}), "edge-123")
+	require.Equal(t, "edge-123", resp.Header.Get("X-Request-ID"))
+	require.Equal(t, "edge-123", received)
+}
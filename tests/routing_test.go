@@ -0,0 +1,330 @@
+package integration_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+)
+
+// newNamedPool starts backend answering with its name and pool of this backend
+func newNamedPool(t *testing.T, name string) *balancer.LoadBalancer {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(name))
+	}))
+	t.Cleanup(backend.Close)
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+	return lb
+}
+
+func newRoutingServer(t *testing.T, fallback *balancer.LoadBalancer, routes []httpGateway.Route) *httptest.Server {
+	t.Helper()
+	rl, err := ratelimit.New(context.Background(), "token_bucket", config.TokenBucketLimiterOptions{
+		DefaultCapacity:         100,
+		DefaultRefillIntervalMS: config.DurationMs(time.Second.Milliseconds()),
+	})
+	require.NoError(t, err)
+	srv := httpGateway.NewServer(t.Context(), fallback, rl, httpGateway.WithRoutes(routes...))
+	apiServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(apiServer.Close)
+	return apiServer
+}
+
+func routedTo(t *testing.T, req *http.Request) (int, string) {
+	t.Helper()
+	req.Header.Set("X-API-Key", "client")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestRouting(t *testing.T) {
+	upstreams := map[string]*balancer.LoadBalancer{
+		"api":    newNamedPool(t, "api"),
+		"static": newNamedPool(t, "static"),
+		"beta":   newNamedPool(t, "beta"),
+	}
+	routes, err := httpGateway.NewRoutes([]config.RouteConfig{
+		{Name: "beta", Match: config.MatchConfig{Headers: map[string]string{"X-Beta": "1"}}, Upstream: "beta"},
+		{Name: "api", Match: config.MatchConfig{PathPrefix: "/api/"}, Upstream: "api"},
+		{Name: "static", Match: config.MatchConfig{Host: "static.example.com"}, Upstream: "static"},
+	}, upstreams, httpGateway.DefaultKeyExtractor())
+	require.NoError(t, err)
+	apiServer := newRoutingServer(t, newNamedPool(t, "default"), routes)
+
+	get := func(path, host string, headers map[string]string) string {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL+path, nil)
+		if host != "" {
+			req.Host = host
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		code, body := routedTo(t, req)
+		require.Equal(t, http.StatusOK, code)
+		return body
+	}
+
+	require.Equal(t, "api", get("/api/users", "", nil))
+	require.Equal(t, "static", get("/logo.png", "static.example.com", nil))
+	require.Equal(t, "beta", get("/api/users", "", map[string]string{"X-Beta": "1"}), "the first matched route wins")
+	require.Equal(t, "api", get("/api/users", "", map[string]string{"X-Beta": "0"}))
+	require.Equal(t, "default", get("/other", "", nil), "not routed requests go to the default pool")
+}
+
+func TestRouting_NoRoute(t *testing.T) {
+	upstreams := map[string]*balancer.LoadBalancer{"api": newNamedPool(t, "api")}
+	routes, err := httpGateway.NewRoutes([]config.RouteConfig{
+		{Name: "api", Match: config.MatchConfig{PathPrefix: "/api/"}, Upstream: "api"},
+	}, upstreams, httpGateway.DefaultKeyExtractor())
+	require.NoError(t, err)
+	apiServer := newRoutingServer(t, nil, routes)
+
+	req, _ := http.NewRequest(http.MethodGet, apiServer.URL+"/api/users", nil)
+	code, body := routedTo(t, req)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "api", body)
+
+	req, _ = http.NewRequest(http.MethodGet, apiServer.URL+"/other", nil)
+	code, body = routedTo(t, req)
+	require.Equal(t, http.StatusNotFound, code)
+	require.JSONEq(t, `{"code":404,"message":"No route"}`, body)
+}
+
+func TestRouting_UnknownUpstream(t *testing.T) {
+	_, err := httpGateway.NewRoutes([]config.RouteConfig{
+		{Name: "api", Match: config.MatchConfig{PathPrefix: "/api/"}, Upstream: "missing"},
+	}, map[string]*balancer.LoadBalancer{}, httpGateway.DefaultKeyExtractor())
+	require.Error(t, err)
+}
+
+func TestRouting_Canary(t *testing.T) {
+	upstreams := map[string]*balancer.LoadBalancer{
+		"stable": newNamedPool(t, "stable"),
+		"canary": newNamedPool(t, "canary"),
+	}
+	routes, err := httpGateway.NewRoutes([]config.RouteConfig{{
+		Name: "api",
+		Split: &config.SplitConfig{
+			Variants: []config.SplitVariantConfig{
+				{Upstream: "stable", Weight: 100},
+				{Upstream: "canary", Weight: 0},
+			},
+			OverrideHeader: "X-Variant",
+		},
+	}}, upstreams, httpGateway.DefaultKeyExtractor())
+	require.NoError(t, err)
+	apiServer := newRoutingServer(t, nil, routes)
+	adminServer := httptest.NewServer(httpGateway.NewAdminServer(httpGateway.WithRoutesAdmin(routes)).Handler())
+	defer adminServer.Close()
+
+	get := func(variant string) string {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+		if variant != "" {
+			req.Header.Set("X-Variant", variant)
+		}
+		code, body := routedTo(t, req)
+		require.Equal(t, http.StatusOK, code)
+		return body
+	}
+	setWeights := func(weights string) int {
+		req, _ := http.NewRequest(http.MethodPut, adminServer.URL+"/routes/api/weights", strings.NewReader(weights))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, "stable", get(""))
+	require.Equal(t, "canary", get("canary"), "header forces the variant")
+
+	require.Equal(t, http.StatusOK, setWeights(`{"stable":0,"canary":100}`))
+	for range 10 {
+		require.Equal(t, "canary", get(""))
+	}
+	require.Equal(t, http.StatusBadRequest, setWeights(`{"missing":1}`))
+
+	resp, err := http.Get(adminServer.URL + "/routes")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"name":"api","weights":{"stable":0,"canary":100}}]`, string(body))
+}
+
+func TestRouting_HeaderRules(t *testing.T) {
+	// Health checks go to the same backend, only the proxied request is captured
+	proxied := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ping" {
+			proxied <- r.Header.Clone()
+		}
+		w.Header().Set("Server", "backend/1.0")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	routes, err := httpGateway.NewRoutes([]config.RouteConfig{{
+		Name:     "api",
+		Upstream: "api",
+		RequestHeaders: config.HeaderRulesConfig{
+			Set:    map[string]string{"X-Client-IP": "{client_ip}"},
+			Remove: []string{"X-Internal"},
+		},
+		ResponseHeaders: config.HeaderRulesConfig{
+			Set:    map[string]string{"X-Served-By": "{backend_url}"},
+			Remove: []string{"Server"},
+		},
+	}}, map[string]*balancer.LoadBalancer{"api": lb}, httpGateway.DefaultKeyExtractor())
+	require.NoError(t, err)
+	apiServer := newRoutingServer(t, nil, routes)
+
+	req, _ := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+	req.Header.Set("X-Internal", "secret")
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	req.Header.Set("X-API-Key", "client")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, backend.URL, resp.Header.Get("X-Served-By"))
+	require.Empty(t, resp.Header.Get("Server"))
+	received := <-proxied
+	require.Equal(t, "127.0.0.1", received.Get("X-Client-IP"))
+	require.Empty(t, received.Get("X-Internal"))
+	require.Equal(t, "127.0.0.1", received.Get("X-Forwarded-For"), "spoofed header of untrusted client is dropped")
+	require.Equal(t, "http", received.Get("X-Forwarded-Proto"))
+	require.Equal(t, strings.TrimPrefix(apiServer.URL, "http://"), received.Get("X-Forwarded-Host"))
+}
+
+func TestRouting_Rewrite(t *testing.T) {
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ping" {
+			received = append(received, r.Host+" "+r.URL.RequestURI())
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	// Backend is mounted at /service
+	backendURL, err := url.Parse(backend.URL + "/service")
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	routes, err := httpGateway.NewRoutes([]config.RouteConfig{
+		{
+			Name:     "v1",
+			Match:    config.MatchConfig{PathPrefix: "/api/v1/"},
+			Upstream: "service",
+			Rewrite:  &config.RewriteConfig{StripPrefix: "/api/v1", Host: "service.internal"},
+		},
+		{
+			Name:     "legacy",
+			Match:    config.MatchConfig{PathPrefix: "/legacy/"},
+			Upstream: "service",
+			Rewrite:  &config.RewriteConfig{Regex: `^/legacy/(\w+)\.php$`, Replacement: "/$1"},
+		},
+	}, map[string]*balancer.LoadBalancer{"service": lb}, httpGateway.DefaultKeyExtractor())
+	require.NoError(t, err)
+	apiServer := newRoutingServer(t, nil, routes)
+
+	for _, path := range []string{"/api/v1/users?page=2", "/api/v1/", "/legacy/orders.php"} {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL+path, nil)
+		code, _ := routedTo(t, req)
+		require.Equal(t, http.StatusOK, code)
+	}
+	require.Equal(t, []string{
+		"service.internal /service/users?page=2",
+		"service.internal /service",
+		strings.TrimPrefix(apiServer.URL, "http://") + " /service/orders",
+	}, received)
+}
+
+func TestRouting_RedirectAndResponse(t *testing.T) {
+	routes, err := httpGateway.NewRoutes([]config.RouteConfig{
+		{
+			Name:     "robots",
+			Match:    config.MatchConfig{PathPrefix: "/robots.txt"},
+			Response: &config.DirectResponseConfig{Body: "User-agent: *\nDisallow: /\n"},
+		},
+		{
+			Name:     "https",
+			Match:    config.MatchConfig{Host: "secure.example.com"},
+			Redirect: &config.RedirectConfig{Status: http.StatusMovedPermanently, Location: "https://{host}{request_uri}"},
+		},
+		{
+			Name:     "maintenance",
+			Response: &config.DirectResponseConfig{Status: http.StatusServiceUnavailable, Body: "maintenance"},
+		},
+	}, map[string]*balancer.LoadBalancer{}, httpGateway.DefaultKeyExtractor())
+	require.NoError(t, err)
+	apiServer := newRoutingServer(t, nil, routes)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	do := func(path, host string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL+path, nil)
+		req.Header.Set("X-API-Key", "client")
+		if host != "" {
+			req.Host = host
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := do("/robots.txt", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "User-agent: *\nDisallow: /\n", string(body))
+
+	resp = do("/login?next=/home", "secure.example.com")
+	require.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	require.Equal(t, "https://secure.example.com/login?next=/home", resp.Header.Get("Location"))
+
+	require.Equal(t, http.StatusServiceUnavailable, do("/other", "").StatusCode)
+
+	_, err = httpGateway.NewRoutes([]config.RouteConfig{{
+		Name:     "both",
+		Upstream: "api",
+		Redirect: &config.RedirectConfig{Location: "/"},
+	}}, map[string]*balancer.LoadBalancer{"api": nil}, httpGateway.DefaultKeyExtractor())
+	require.Error(t, err, "route has only one action")
+}
@@ -0,0 +1,105 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zahartd/load_balancer/internal/balancer"
+	"github.com/zahartd/load_balancer/internal/config"
+	httpGateway "github.com/zahartd/load_balancer/internal/gateways/http"
+	"github.com/zahartd/load_balancer/internal/ratelimit"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := balancer.New(
+		t.Context(),
+		[]config.BackendConfig{{URL: backendURL}},
+		config.LoadBalancerConfig{Algorithm: "round_robin", HealthCheckIntervalMS: 50},
+	)
+	require.Eventually(t, func() bool { return lb.AliveBackends() == 1 }, time.Second, 50*time.Millisecond)
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	rl, err := ratelimit.New(context.Background(), "token_bucket", config.TokenBucketLimiterOptions{
+		DefaultCapacity:         10,
+		DefaultRefillIntervalMS: config.DurationMs(time.Hour.Milliseconds()),
+	})
+	require.NoError(t, err)
+	newServer := func(trusted config.CIDRList) *httptest.Server {
+		srv := httpGateway.NewServer(
+			t.Context(), lb, rl, httpGateway.WithTracerProvider(tp), httpGateway.WithTrustedProxies(trusted),
+		)
+		apiServer := httptest.NewServer(srv.Handler())
+		t.Cleanup(apiServer.Close)
+		return apiServer
+	}
+	const callerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	do := func(apiServer *httptest.Server) {
+		req, _ := http.NewRequest(http.MethodGet, apiServer.URL+"/users", nil)
+		req.Header.Set("X-API-Key", "client")
+		req.Header.Set("traceparent", "00-"+callerTraceID+"-00f067aa0ba902b7-01")
+		req.Header.Set("tracestate", "vendor=value")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// Trace of untrusted client is not continued
+	do(newServer(nil))
+	ended := recorder.Ended()
+	require.NotEmpty(t, ended)
+	for _, span := range ended {
+		require.NotEqual(t, callerTraceID, span.SpanContext().TraceID().String())
+	}
+	require.NotContains(t, traceparent, callerTraceID)
+	recorder.Reset()
+
+	// Trace of trusted proxy is continued
+	do(newServer(config.CIDRList{netip.MustParsePrefix("127.0.0.0/8")}))
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "GET")
+	require.Contains(t, spans, "ratelimit")
+	require.Contains(t, spans, "balancer.next_backend")
+	require.Contains(t, spans, "upstream GET")
+
+	server := spans["GET"]
+	require.Equal(t, callerTraceID, server.SpanContext().TraceID().String())
+	require.Equal(t, trace.SpanKindServer, server.SpanKind())
+	for _, name := range []string{"ratelimit", "balancer.next_backend", "upstream GET"} {
+		require.Equal(t, server.SpanContext().SpanID(), spans[name].Parent().SpanID(), "%s is child of the server span", name)
+	}
+
+	upstream := spans["upstream GET"]
+	attrs := make(map[string]string)
+	for _, kv := range upstream.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	require.Equal(t, backend.URL, attrs["url.full"])
+	require.Equal(t, "200", attrs["http.response.status_code"])
+
+	// Backend continues the trace from the upstream span
+	require.Equal(t, "00-"+callerTraceID+"-"+upstream.SpanContext().SpanID().String()+"-01", traceparent)
+	require.Equal(t, "vendor=value", upstream.SpanContext().TraceState().String())
+}